@@ -0,0 +1,129 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+var annotateInitialConfig bool = false
+
+// SetAnnotateInitialConfig writes the descriptions of the schema as comments into the config file Load creates when there is none yet.
+// The default config is always annotated
+func SetAnnotateInitialConfig(annotate bool) {
+	annotateInitialConfig = annotate
+}
+
+// saveAnnotated saves struct to toml with the descriptions of the schema as comments
+func saveAnnotated(structure interface{}, filename string) error {
+	data, err := encodeConfig(structure)
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(configFileName(filename, ConfigFormat_TOML), annotateTOML(data, GetSchema(structure)))
+}
+
+// annotateTOML adds the label, description, options, range and required message of every key in the schema as comments above it.
+// Elements of structure arrays are only annotated once, on their first element
+func annotateTOML(data []byte, schema *cs.ValueTypeDescriptor) []byte {
+	doc := string(data)
+	entries, tables, err := scanTOML(doc)
+	if err != nil {
+		return data
+	}
+
+	type insertion struct {
+		at   int
+		text string
+	}
+	insertions := make([]insertion, 0, len(entries)+len(tables))
+
+	annotate := func(path string, lineStart int) {
+		keys := strings.Split(path, pathSeparator)
+		for _, key := range keys {
+			if index, err := strconv.Atoi(key); err == nil && index > 0 {
+				return
+			}
+		}
+		vtd := descriptorForKeys(schema, keys)
+		if vtd == nil {
+			return
+		}
+		lines := annotationLines(vtd)
+		if len(lines) == 0 {
+			return
+		}
+
+		line := doc[lineStart:]
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		var text strings.Builder
+		for _, l := range lines {
+			text.WriteString(indent + "# " + l + "\n")
+		}
+		insertions = append(insertions, insertion{lineStart, text.String()})
+	}
+
+	for _, entry := range entries {
+		annotate(entry.path, entry.lineStart)
+	}
+	for path, table := range tables {
+		if table.headerStart >= 0 {
+			annotate(path, table.headerStart)
+		}
+	}
+
+	sort.Slice(insertions, func(i, j int) bool { return insertions[i].at > insertions[j].at })
+	for _, in := range insertions {
+		doc = doc[:in.at] + in.text + doc[in.at:]
+	}
+	return []byte(doc)
+}
+
+// descriptorForKeys returns the schema for the keys of a toml path, elements of structure arrays are addressed by index
+func descriptorForKeys(schema *cs.ValueTypeDescriptor, keys []string) *cs.ValueTypeDescriptor {
+	vtd := schema
+	for i := 0; i < len(keys) && vtd != nil; i++ {
+		switch vtd.Type {
+		case cs.ValueType_Structure:
+			vtd = vtd.StructureSubtypes[keys[i]]
+		case cs.ValueType_StructureArray:
+			if _, err := strconv.Atoi(keys[i]); err != nil {
+				return nil
+			}
+			if i == len(keys)-1 {
+				return vtd // The header of an element describes the whole array
+			}
+			i++
+			vtd = vtd.StructureSubtypes[keys[i]]
+		default:
+			return nil
+		}
+	}
+	return vtd
+}
+
+func annotationLines(vtd *cs.ValueTypeDescriptor) []string {
+	var lines []string
+	if vtd.Label != "" {
+		lines = append(lines, vtd.Label)
+	}
+	if vtd.Description != "" {
+		lines = append(lines, strings.Split(vtd.Description, "\n")...)
+	}
+	switch vtd.Type {
+	case cs.ValueType_Select, cs.ValueType_IntegerSelect, cs.ValueType_MultiSelect:
+		if len(vtd.Options) > 0 {
+			lines = append(lines, "Options: "+strings.Join(vtd.Options, ", "))
+		}
+	case cs.ValueType_Port:
+		lines = append(lines, "Range: 0 - 65535")
+	case cs.ValueType_UniqueInc:
+		lines = append(lines, "Unique number")
+	}
+	if vtd.Required != "" {
+		lines = append(lines, "Required: "+vtd.Required)
+	}
+	return lines
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	config "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+func cmdGet(c *coreFiles, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: get <path>")
+	}
+	doc, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	value, err := getPath(doc, splitPath(args[0]))
+	if err != nil {
+		return err
+	}
+	printValue(value)
+	return nil
+}
+
+func cmdSet(c *coreFiles, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: set <path> <value>")
+	}
+	doc, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	schema, err := c.loadSchema()
+	if err != nil {
+		return err
+	}
+
+	segments := splitPath(args[0])
+	vtd, err := descriptorFor(schema, segments)
+	if err != nil {
+		return err
+	}
+	value, err := parseValue(vtd, args[1])
+	if err != nil {
+		return fmt.Errorf("on parsing value for %s: %w", args[0], err)
+	}
+	if err := setPath(doc, segments, value); err != nil {
+		return err
+	}
+	return c.saveConfig(doc)
+}
+
+func cmdUnset(c *coreFiles, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: unset <path>")
+	}
+	doc, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	if err := unsetPath(doc, splitPath(args[0])); err != nil {
+		return err
+	}
+	return c.saveConfig(doc)
+}
+
+func cmdValidate(c *coreFiles, args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	strict := flags.Bool("strict", false, "fail on values that do not exist in the schema")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	doc, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	schema, err := c.loadSchema()
+	if err != nil {
+		return err
+	}
+	return reportViolations(c.configPath(), schema, doc, *strict)
+}
+
+func cmdCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	strict := flags.Bool("strict", false, "fail on values that do not exist in the schema")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return fmt.Errorf("usage: check [-strict] <config.toml|config.json|config.yaml> <schema.json>")
+	}
+
+	doc, err := readConfigFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	schema, err := readSchema(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	return reportViolations(flags.Arg(0), schema, doc, *strict)
+}
+
+func cmdCompat(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: compat <old.schema.json> <new.schema.json>")
+	}
+	oldSchema, err := readSchema(args[0])
+	if err != nil {
+		return err
+	}
+	newSchema, err := readSchema(args[1])
+	if err != nil {
+		return err
+	}
+
+	changes := config.CompareSchemas(oldSchema, newSchema)
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if config.HasBreakingChanges(changes) {
+		return fmt.Errorf("%s has breaking changes", args[1])
+	}
+	fmt.Println("no breaking changes")
+	return nil
+}
+
+// reportViolations prints every violation of a config and fails if there are any
+func reportViolations(name string, schema *cs.ValueTypeDescriptor, doc map[string]interface{}, strict bool) error {
+	_, violations := config.ValidateConfigAll(schema, doc, strict, name)
+	for _, violation := range violations {
+		fmt.Println(violation)
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%s is invalid, found %d violations", name, len(violations))
+	}
+	fmt.Println(name, "is valid")
+	return nil
+}
+
+func cmdDiff(c *coreFiles, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: diff")
+	}
+	doc, err := c.loadConfig()
+	if err != nil {
+		return err
+	}
+	defaults, err := readTOML(c.defaultPath())
+	if err != nil {
+		return err
+	}
+
+	current := make(map[string]interface{})
+	flatten("", doc, current)
+	def := make(map[string]interface{})
+	flatten("", defaults, def)
+
+	keys := make([]string, 0, len(current)+len(def))
+	for key := range current {
+		keys = append(keys, key)
+	}
+	for key := range def {
+		if _, ok := current[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		currentValue, inCurrent := current[key]
+		defaultValue, inDefault := def[key]
+		switch {
+		case !inDefault:
+			fmt.Printf("+ %s = %s\n", key, formatValue(currentValue))
+		case !inCurrent:
+			fmt.Printf("- %s = %s\n", key, formatValue(defaultValue))
+		case !reflect.DeepEqual(currentValue, defaultValue):
+			fmt.Printf("~ %s = %s (default %s)\n", key, formatValue(currentValue), formatValue(defaultValue))
+		}
+	}
+	return nil
+}
+
+// flatten collects all leaf values of a document by their dotted path
+func flatten(prefix string, value interface{}, out map[string]interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + "." + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, sub := range v {
+			flatten(join(key), sub, out)
+		}
+	case []map[string]interface{}:
+		for i, sub := range v {
+			flatten(join(fmt.Sprint(i)), sub, out)
+		}
+	default:
+		out[prefix] = value
+	}
+}
+
+func formatValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+func printValue(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}, []map[string]interface{}, []interface{}:
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err == nil {
+			fmt.Println(string(data))
+			return
+		}
+	}
+	fmt.Println(strings.TrimSpace(fmt.Sprint(value)))
+}
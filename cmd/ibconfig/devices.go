@@ -0,0 +1,269 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	config "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// loadDevices returns the config, the device array path, its schema, its elements and the devices resolved by config.Devices
+func loadDevices(c *coreFiles) (map[string]interface{}, []string, *cs.ValueTypeDescriptor, []map[string]interface{}, []config.Device, error) {
+	doc, err := c.loadConfig()
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	schema, err := c.loadSchema()
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	path, vtd := config.DeviceArray(schema)
+	if vtd == nil {
+		return nil, nil, nil, nil, nil, fmt.Errorf("schema has no device array")
+	}
+	devices, err := config.Devices(doc, schema)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+
+	var elements []map[string]interface{}
+	value, err := getPath(doc, path)
+	if err == nil {
+		switch v := value.(type) {
+		case []map[string]interface{}:
+			elements = v
+		case []interface{}:
+			for i, element := range v {
+				device, ok := element.(map[string]interface{})
+				if !ok {
+					return nil, nil, nil, nil, nil, fmt.Errorf("device %d is no structure", i)
+				}
+				elements = append(elements, device)
+			}
+		default:
+			return nil, nil, nil, nil, nil, fmt.Errorf("%s is no array", strings.Join(path, "."))
+		}
+	}
+	if len(elements) != len(devices) {
+		return nil, nil, nil, nil, nil, fmt.Errorf("%s is stored with a different case than in the schema, rename it to edit devices", strings.Join(path, "."))
+	}
+	return doc, path, vtd, elements, devices, nil
+}
+
+func cmdListDevices(c *coreFiles, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("usage: list-devices")
+	}
+	_, _, vtd, _, devices, err := loadDevices(c)
+	if err != nil {
+		return err
+	}
+
+	columns := []struct {
+		title string
+		roles []string
+		value func(device config.Device) interface{}
+	}{
+		{"ID", []string{config.Dispatch_DeviceID}, func(d config.Device) interface{} { return d.ID }},
+		{"MODEL", []string{config.Dispatch_ModelID}, func(d config.Device) interface{} { return d.ModelID }},
+		{"ACTIVE", []string{config.Dispatch_Active}, func(d config.Device) interface{} { return d.Active }},
+		{"NAME", []string{config.Dispatch_Name}, func(d config.Device) interface{} { return d.Name }},
+		{"IP", []string{config.Dispatch_DeviceIP, config.Dispatch_IP}, func(d config.Device) interface{} { return d.IP }},
+		{"PORT", []string{config.Dispatch_Port}, func(d config.Device) interface{} { return d.Port }},
+		{"DESCRIPTION", []string{config.Dispatch_Description}, func(d config.Device) interface{} { return d.Description }},
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for i, column := range columns {
+		if i > 0 {
+			fmt.Fprint(w, "\t")
+		}
+		fmt.Fprint(w, column.title)
+	}
+	fmt.Fprintln(w)
+	for _, device := range devices {
+		for i, column := range columns {
+			if i > 0 {
+				fmt.Fprint(w, "\t")
+			}
+			if config.DispatchField(vtd, column.roles...) != "" {
+				fmt.Fprint(w, column.value(device))
+			} else {
+				fmt.Fprint(w, "-")
+			}
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
+
+func cmdAddDevice(c *coreFiles, args []string) error {
+	flags := flag.NewFlagSet("add-device", flag.ContinueOnError)
+	presetName := flags.String("preset", "", "name of a preset of the device array in the schema")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	args = flags.Args()
+
+	doc, path, vtd, elements, devices, err := loadDevices(c)
+	if err != nil {
+		return err
+	}
+	idField := config.DispatchField(vtd, config.Dispatch_DeviceID)
+	if idField == "" {
+		return fmt.Errorf("device schema has no deviceid field")
+	}
+
+	device := make(map[string]interface{})
+	for name, sub := range vtd.StructureSubtypes {
+		if sub != nil {
+			device[name] = defaultValue(sub)
+		}
+	}
+
+	assigned := make(map[string]bool)
+	if *presetName != "" {
+		var preset *cs.Preset
+		for i := range vtd.Presets {
+			if vtd.Presets[i].Name == *presetName {
+				preset = &vtd.Presets[i]
+			}
+		}
+		if preset == nil {
+			return fmt.Errorf("preset %q does not exist in schema", *presetName)
+		}
+		for name, value := range preset.Values {
+			sub, ok := vtd.StructureSubtypes[name]
+			if !ok || sub == nil {
+				return fmt.Errorf("preset %q sets %s which does not exist in schema", *presetName, name)
+			}
+			device[name] = defaultValue(&cs.ValueTypeDescriptor{Type: sub.Type, Default: value})
+			assigned[name] = true
+		}
+	}
+
+	var nextID int64 = 1
+	for _, existing := range devices {
+		if int64(existing.ID) >= nextID {
+			nextID = int64(existing.ID) + 1
+		}
+	}
+	device[idField] = nextID
+
+	for _, arg := range args {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid field assignment %q, use Field=value", arg)
+		}
+		sub, ok := vtd.StructureSubtypes[parts[0]]
+		if !ok || sub == nil {
+			return fmt.Errorf("device field %s does not exist in schema", parts[0])
+		}
+		value, err := parseValue(sub, parts[1])
+		if err != nil {
+			return fmt.Errorf("on parsing value for %s: %w", parts[0], err)
+		}
+		device[parts[0]] = value
+		assigned[parts[0]] = true
+	}
+
+	if model, ok := toInt(device[config.DispatchField(vtd, config.Dispatch_ModelID)]); ok {
+		for name, sub := range vtd.StructureSubtypes {
+			if sub == nil || assigned[name] {
+				continue
+			}
+			if def, found := sub.DefaultOnModel[int(model)]; found {
+				device[name] = defaultValue(&cs.ValueTypeDescriptor{Type: sub.Type, Default: def})
+			}
+		}
+	}
+
+	if err := setPath(doc, path, append(elements, device)); err != nil {
+		return err
+	}
+	if err := c.saveConfig(doc); err != nil {
+		return err
+	}
+	fmt.Println("added device", nextID)
+	return nil
+}
+
+func cmdRemoveDevice(c *coreFiles, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: remove-device <deviceid>")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("%q is no device id", args[0])
+	}
+
+	doc, path, _, elements, devices, err := loadDevices(c)
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]map[string]interface{}, 0, len(elements))
+	for i, element := range elements {
+		if int64(devices[i].ID) == id {
+			continue
+		}
+		remaining = append(remaining, element)
+	}
+	if len(remaining) == len(elements) {
+		return fmt.Errorf("device %d does not exist", id)
+	}
+
+	if err := setPath(doc, path, remaining); err != nil {
+		return err
+	}
+	return c.saveConfig(doc)
+}
+
+// defaultValue returns the value a new structure array element gets for a field
+func defaultValue(vtd *cs.ValueTypeDescriptor) interface{} {
+	if vtd.Default != nil {
+		if num, ok := vtd.Default.(float64); ok && vtd.Type != cs.ValueType_Float {
+			return int64(num)
+		}
+		return vtd.Default
+	}
+
+	switch vtd.Type {
+	case cs.ValueType_Integer, cs.ValueType_Port, cs.ValueType_UniqueInc, cs.ValueType_IntegerSelect:
+		return int64(0)
+	case cs.ValueType_Float:
+		return float64(0)
+	case cs.ValueType_Checkbox:
+		return false
+	case cs.ValueType_Structure:
+		structure := make(map[string]interface{})
+		for name, sub := range vtd.StructureSubtypes {
+			if sub != nil {
+				structure[name] = defaultValue(sub)
+			}
+		}
+		return structure
+	case cs.ValueType_Array, cs.ValueType_MultiSelect:
+		return []interface{}{}
+	case cs.ValueType_StructureArray:
+		return []map[string]interface{}{}
+	}
+	return ""
+}
+
+func toInt(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case float64:
+		return int64(v), true
+	}
+	return 0, false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	config "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// coreFiles locates the files a core stores in its config directory
+type coreFiles struct {
+	dir  string
+	core string
+}
+
+func newCoreFiles(dir, core string) *coreFiles {
+	return &coreFiles{dir: dir, core: core}
+}
+
+// configPath returns the config file of the core in the first format that exists, toml if there is none
+func (c *coreFiles) configPath() string {
+	for _, format := range []config.ConfigFormat{config.ConfigFormat_TOML, config.ConfigFormat_JSON, config.ConfigFormat_YAML} {
+		file := filepath.Join(c.dir, c.core+"."+format)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+	return filepath.Join(c.dir, c.core+".toml")
+}
+
+func (c *coreFiles) schemaPath() string {
+	return filepath.Join(c.dir, c.core+".schema.json")
+}
+
+func (c *coreFiles) defaultPath() string {
+	return filepath.Join(c.dir, c.core+".default.toml")
+}
+
+func (c *coreFiles) loadConfig() (map[string]interface{}, error) {
+	return readConfigFile(c.configPath())
+}
+
+func (c *coreFiles) loadSchema() (*cs.ValueTypeDescriptor, error) {
+	return readSchema(c.schemaPath())
+}
+
+// saveConfig type checks the config against the schema and writes it, nothing is written if the check fails.
+// TOML files are patched, so comments and formatting added by hand are kept
+func (c *coreFiles) saveConfig(doc map[string]interface{}) error {
+	schema, err := c.loadSchema()
+	if err != nil {
+		return err
+	}
+
+	cleaned, err := config.ValidateConfig(schema, doc, false, c.core)
+	if err != nil {
+		return fmt.Errorf("config not saved, validation failed: %w", err)
+	}
+
+	cleanedDoc, ok := cleaned.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("config not saved, schema root is no structure")
+	}
+	file := c.configPath()
+	existing, err := os.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	data, err := config.PatchDocument(fileFormat(file), existing, cleanedDoc) // Keeps comments of hand edited files
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(file + ".sha256")
+	integrity := err == nil // Config of a core in integrity mode
+	if integrity {
+		if err := config.CheckIntegrityUpdate(file); err != nil { // A stale checksum would make the core discard the change
+			return fmt.Errorf("config not saved: %w", err)
+		}
+	}
+	if err := writeFileAtomic(file, data); err != nil {
+		return err
+	}
+	if integrity {
+		return config.UpdateIntegrity(file, data)
+	}
+	return nil
+}
+
+func readTOML(file string) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if _, err := toml.DecodeFile(file, &doc); err != nil {
+		return nil, fmt.Errorf("on reading %s: %w", file, err)
+	}
+	return doc, nil
+}
+
+// readConfigFile reads a TOML, JSON or YAML config file, depending on its extension
+func readConfigFile(file string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := config.DecodeDocument(fileFormat(file), data)
+	if err != nil {
+		return nil, fmt.Errorf("on reading %s: %w", file, err)
+	}
+	return doc, nil
+}
+
+// fileFormat returns the config format of a file by its extension, unknown extensions are read as toml
+func fileFormat(file string) config.ConfigFormat {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+	if format == "yml" {
+		return config.ConfigFormat_YAML
+	}
+	if config.GetCodec(format) == nil {
+		return config.ConfigFormat_TOML
+	}
+	return format
+}
+
+func readSchema(file string) (*cs.ValueTypeDescriptor, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("on reading schema: %w", err)
+	}
+	schema := new(cs.ValueTypeDescriptor)
+	if err := json.Unmarshal(data, schema); err != nil {
+		return nil, fmt.Errorf("on decoding schema %s: %w", file, err)
+	}
+	return schema, nil
+}
+
+// writeFileAtomic writes to a temporary file first, so a failed write never leaves a half written config behind
+func writeFileAtomic(file string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(file); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp := file + ".tmp"
+	if err := os.WriteFile(tmp, data, mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	config "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+type testDevice struct {
+	config.BaseDeviceConfig
+	IP   string `ibValidate:"ip" ibDispatch:"ip"`
+	Port int    `ibValidate:"port" ibDispatch:"port" ibDefault:"9910" ibDefaultOnModel:"7=52381"`
+}
+
+type testConfig struct {
+	Global struct {
+		PollMs int
+		Mode   string `ibOptions:"fast,slow"`
+	}
+	Devices []testDevice `ibDispatch:"devices"`
+}
+
+// setupCore writes the schema of testConfig and a config file to a temporary directory
+func setupCore(t *testing.T, configFile string) *coreFiles {
+	t.Helper()
+	c := newCoreFiles(t.TempDir(), "core-test")
+	schema, err := json.Marshal(config.GetSchema(&testConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(c.schemaPath(), schema, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(c.dir, c.core+".toml"), []byte(configFile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func readFile(t *testing.T, file string) string {
+	t.Helper()
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPaths(t *testing.T) {
+	doc := map[string]interface{}{
+		"Global":  map[string]interface{}{"PollMs": int64(100)},
+		"Devices": []map[string]interface{}{{"DeviceID": int64(1)}},
+		"Tags":    []interface{}{"a", "b"},
+	}
+
+	tests := []struct {
+		path     string
+		expected interface{}
+		fails    bool
+	}{
+		{path: "Global.PollMs", expected: int64(100)},
+		{path: "Devices.0.DeviceID", expected: int64(1)},
+		{path: "Tags.1", expected: "b"},
+		{path: "Global.Missing", fails: true},
+		{path: "Devices.1", fails: true},
+		{path: "Devices.first", fails: true},
+		{path: "Global.PollMs.Value", fails: true},
+	}
+	for _, test := range tests {
+		value, err := getPath(doc, splitPath(test.path))
+		if test.fails {
+			if err == nil {
+				t.Errorf("expected error for %s, got %v", test.path, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on getting %s: %v", test.path, err)
+		} else if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("expected %v for %s, got %v", test.expected, test.path, value)
+		}
+	}
+
+	if err := setPath(doc, splitPath("Network.Timeout"), int64(5)); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := getPath(doc, splitPath("Network.Timeout")); value != int64(5) {
+		t.Errorf("expected missing structure to be created, got %v", doc["Network"])
+	}
+	if err := setPath(doc, splitPath("Devices.0"), int64(5)); err == nil {
+		t.Errorf("expected error for setting a structure array element to a number")
+	}
+	if err := setPath(doc, splitPath("Tags.5"), "c"); err == nil {
+		t.Errorf("expected error for index out of range")
+	}
+
+	if err := unsetPath(doc, splitPath("Global.PollMs")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getPath(doc, splitPath("Global.PollMs")); err == nil {
+		t.Errorf("expected Global.PollMs to be unset")
+	}
+	if err := unsetPath(doc, splitPath("Global.PollMs")); err == nil {
+		t.Errorf("expected error for unsetting a missing key")
+	}
+	if err := unsetPath(doc, splitPath("Tags.0")); err == nil {
+		t.Errorf("expected error for unsetting an array element")
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	selectType := &cs.ValueTypeDescriptor{Type: cs.ValueType_Select, Options: []string{"a", "b"}}
+	tests := []struct {
+		vtd      *cs.ValueTypeDescriptor
+		raw      string
+		expected interface{}
+		fails    bool
+	}{
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Integer}, raw: "42", expected: int64(42)},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Port}, raw: "port", fails: true},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Float}, raw: "1.5", expected: 1.5},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Float}, raw: "fast", fails: true},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Checkbox}, raw: "true", expected: true},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Checkbox}, raw: "yes", fails: true},
+		{vtd: selectType, raw: "b", expected: "b"},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Array, ArraySubType: &cs.ValueTypeDescriptor{Type: cs.ValueType_Integer}}, raw: "1, 2", expected: []interface{}{int64(1), int64(2)}},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Array, ArraySubType: &cs.ValueTypeDescriptor{Type: cs.ValueType_Integer}}, raw: "1,x", fails: true},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_MultiSelect, Options: []string{"a", "b"}}, raw: "a,b", expected: []interface{}{"a", "b"}},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Array}, raw: `["x"]`, expected: []interface{}{"x"}},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_StructureArray}, raw: `[{"Name":"x"}]`, expected: []map[string]interface{}{{"Name": "x"}}},
+		{vtd: &cs.ValueTypeDescriptor{Type: cs.ValueType_Structure}, raw: `{"Name":`, fails: true},
+	}
+	for _, test := range tests {
+		value, err := parseValue(test.vtd, test.raw)
+		if test.fails {
+			if err == nil {
+				t.Errorf("expected error for %q as type %d, got %v", test.raw, test.vtd.Type, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("on parsing %q: %v", test.raw, err)
+		} else if !reflect.DeepEqual(value, test.expected) {
+			t.Errorf("expected %#v for %q, got %#v", test.expected, test.raw, value)
+		}
+	}
+}
+
+func TestSetKeepsComments(t *testing.T) {
+	c := setupCore(t, "# Written by hand\n[Global]\n# Poll interval\nPollMs = 100 # milliseconds\nMode = \"fast\"\n")
+
+	if err := cmdSet(c, []string{"Global.PollMs", "200"}); err != nil {
+		t.Fatal(err)
+	}
+	content := readFile(t, c.configPath())
+	for _, expected := range []string{"# Written by hand", "# Poll interval", "PollMs = 200 # milliseconds"} {
+		if !strings.Contains(content, expected) {
+			t.Errorf("expected %q in saved config, got:\n%s", expected, content)
+		}
+	}
+
+	if err := cmdUnset(c, []string{"Global.Mode"}); err != nil {
+		t.Fatal(err)
+	}
+	doc, err := c.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getPath(doc, splitPath("Global.Mode")); err == nil {
+		t.Errorf("expected Global.Mode to be removed, got:\n%s", readFile(t, c.configPath()))
+	}
+}
+
+func TestInvalidWritesAreRejected(t *testing.T) {
+	original := "[Global]\nPollMs = 100\nMode = \"fast\"\n"
+	c := setupCore(t, original)
+
+	invalid := [][]string{
+		{"Global.Mode", "medium"},                        // Not an option
+		{"Global.PollMs", "often"},                       // No integer
+		{"Global.Missing", "1"},                          // Not in schema
+		{"Devices.0.Port", "99999999"},                   // No device 0
+		{"Devices", `[{"DeviceID": 1, "Port": "fast"}]`}, // Port of wrong type, found by ValidateConfig
+	}
+	for _, args := range invalid {
+		if err := cmdSet(c, args); err == nil {
+			t.Errorf("expected set %s %s to fail", args[0], args[1])
+		}
+	}
+	if content := readFile(t, c.configPath()); content != original {
+		t.Errorf("expected config to be unchanged, got:\n%s", content)
+	}
+	if err := cmdAddDevice(c, []string{"Port=fast"}); err == nil {
+		t.Errorf("expected add-device with invalid port to fail")
+	}
+	if err := cmdAddDevice(c, []string{"Unknown=1"}); err == nil {
+		t.Errorf("expected add-device with unknown field to fail")
+	}
+	if content := readFile(t, c.configPath()); content != original {
+		t.Errorf("expected config to be unchanged after failed add-device, got:\n%s", content)
+	}
+}
+
+func TestDeviceCommands(t *testing.T) {
+	c := setupCore(t, "[Global]\nPollMs = 100\nMode = \"fast\"\n\n[[Devices]]\nDeviceID = 4\nModelID = 1\nName = \"Cam 4\"\nIP = \"10.0.0.4\"\nPort = 9910\n")
+
+	if err := cmdAddDevice(c, []string{"ModelID=7", "Name=PTZ"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdAddDevice(c, []string{"ModelID=7", "Port=1234"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdAddDevice(c, []string{"ModelID=2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := c.loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		id   int64
+		port int64
+	}{
+		{4, 9910},
+		{5, 52381}, // ibDefaultOnModel of model 7
+		{6, 1234},  // Given values win over model defaults
+		{7, 9910},  // ibDefault for other models
+	}
+	for i, device := range expected {
+		if id, _ := getPath(doc, splitPath("Devices."+strconv.Itoa(i)+".DeviceID")); id != device.id {
+			t.Errorf("expected device %d to get id %d, got %v", i, device.id, id)
+		}
+		if port, _ := getPath(doc, splitPath("Devices."+strconv.Itoa(i)+".Port")); port != device.port {
+			t.Errorf("expected device %d to get port %d, got %v", i, device.port, port)
+		}
+	}
+
+	if err := cmdRemoveDevice(c, []string{"5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdRemoveDevice(c, []string{"5"}); err == nil {
+		t.Errorf("expected error for removing a missing device")
+	}
+	if doc, err = c.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	devices, _ := getPath(doc, splitPath("Devices"))
+	for _, device := range devices.([]map[string]interface{}) {
+		ids = append(ids, device["DeviceID"].(int64))
+	}
+	if !reflect.DeepEqual(ids, []int64{4, 6, 7}) {
+		t.Errorf("expected devices 4, 6 and 7 after removing 5, got %v", ids)
+	}
+
+	if err := cmdAddDevice(c, nil); err != nil {
+		t.Fatal(err)
+	}
+	if doc, err = c.loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := getPath(doc, splitPath("Devices.3.DeviceID")); id != int64(8) {
+		t.Errorf("expected new device to get id 8 after the highest id, got %v", id)
+	}
+}
+
+func TestHMACChecksumWithoutKey(t *testing.T) {
+	original := "[Global]\nPollMs = 100\nMode = \"fast\"\n"
+	c := setupCore(t, original)
+	checksum := "hmac-sha256 0000000000000000000000000000000000000000000000000000000000000000\n"
+	if err := os.WriteFile(c.configPath()+".sha256", []byte(checksum), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmdSet(c, []string{"Global.PollMs", "200"}); err == nil {
+		t.Errorf("expected set to fail without the integrity key")
+	}
+	if content := readFile(t, c.configPath()); content != original {
+		t.Errorf("expected config to be unchanged, got:\n%s", content)
+	}
+	if content := readFile(t, c.configPath()+".sha256"); content != checksum {
+		t.Errorf("expected checksum to be unchanged, got %s", content)
+	}
+}
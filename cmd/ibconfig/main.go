@@ -0,0 +1,108 @@
+// Command ibconfig inspects and edits the config of a core
+//
+//	ibconfig -core core-example get Devices.0.IP
+//	ibconfig -core core-example set Devices.0.IP 10.0.0.20
+//
+// The config is read from /var/ibeam/config/<core>/<core>.toml, use -dir to work on another directory.
+// Every write is type checked with config.ValidateConfig against <core>.schema.json before it is saved.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const skaarOSpath = "/var/ibeam/config"
+
+const usage = `Usage: %s [-core name] [-dir path] <command> [args]
+
+Commands:
+  get <path>                     print the value at a dotted path (eg. Devices.0.IP)
+  set <path> <value>             set the value at a path, arrays and structures are given as JSON
+  unset <path>                   remove the value at a path
+  validate [-strict]             validate the config against <core>.schema.json
+  diff                           show differences to <core>.default.toml
+  list-devices                   list the devices of the config
+  add-device [-preset name] [Field=value ...]
+                                 add a device with the next free DeviceID, optionally from a preset of the schema
+  remove-device <deviceid>       remove the device with the given DeviceID
+
+Commands that do not need a core:
+  check [-strict] <config.toml|config.json|config.yaml> <schema.json>
+                                 validate a config file against a schema file and print every violation
+  compat <old.schema.json> <new.schema.json>
+                                 list the differences between two schema versions, fails on breaking changes
+
+Flags:
+`
+
+type commandFunc func(c *coreFiles, args []string) error
+
+var commands = map[string]commandFunc{
+	"get":           cmdGet,
+	"set":           cmdSet,
+	"unset":         cmdUnset,
+	"validate":      cmdValidate,
+	"diff":          cmdDiff,
+	"list-devices":  cmdListDevices,
+	"add-device":    cmdAddDevice,
+	"remove-device": cmdRemoveDevice,
+}
+
+// standaloneCommands work on files given as arguments and do not need a core
+var standaloneCommands = map[string]func(args []string) error{
+	"check":  cmdCheck,
+	"compat": cmdCompat,
+}
+
+func main() {
+	core := flag.String("core", "", "name of the core, defaults to the name of -dir")
+	dir := flag.String("dir", "", "directory containing the config files, defaults to "+skaarOSpath+"/<core>")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if standalone, ok := standaloneCommands[flag.Arg(0)]; ok {
+		exitOnError(standalone(flag.Args()[1:]))
+		return
+	}
+
+	command, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *core == "" && *dir != "" {
+		abs, err := filepath.Abs(*dir)
+		if err == nil {
+			*core = filepath.Base(abs)
+		}
+	}
+	if *core == "" {
+		fmt.Fprintln(os.Stderr, "please specify the core with -core")
+		os.Exit(2)
+	}
+	if *dir == "" {
+		*dir = filepath.Join(skaarOSpath, *core)
+	}
+
+	exitOnError(command(newCoreFiles(*dir, *core), flag.Args()[1:]))
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+func splitPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+func parseIndex(segment string, length int) (int, error) {
+	index, err := strconv.Atoi(segment)
+	if err != nil {
+		return 0, fmt.Errorf("%q is no array index", segment)
+	}
+	if index < 0 || index >= length {
+		return 0, fmt.Errorf("index %d out of range, array has %d elements", index, length)
+	}
+	return index, nil
+}
+
+// getPath returns the value at a path, arrays of tables are decoded as []map[string]interface{} and addressed by index
+func getPath(doc interface{}, segments []string) (interface{}, error) {
+	current := doc
+	for i, segment := range segments {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			next, ok := value[segment]
+			if !ok {
+				return nil, fmt.Errorf("%s is not set", strings.Join(segments[:i+1], "."))
+			}
+			current = next
+		case []map[string]interface{}:
+			index, err := parseIndex(segment, len(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", strings.Join(segments[:i], "."), err)
+			}
+			current = value[index]
+		case []interface{}:
+			index, err := parseIndex(segment, len(value))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", strings.Join(segments[:i], "."), err)
+			}
+			current = value[index]
+		default:
+			return nil, fmt.Errorf("%s is no structure or array", strings.Join(segments[:i], "."))
+		}
+	}
+	return current, nil
+}
+
+// setPath sets the value at a path, missing structures are created on the way
+func setPath(doc map[string]interface{}, segments []string, newValue interface{}) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no path given")
+	}
+
+	parentSegments, last := segments[:len(segments)-1], segments[len(segments)-1]
+	current := interface{}(doc)
+	for i, segment := range parentSegments {
+		if m, ok := current.(map[string]interface{}); ok {
+			if _, exists := m[segment]; !exists {
+				m[segment] = make(map[string]interface{})
+			}
+		}
+		next, err := getPath(current, []string{segment})
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.Join(segments[:i], "."), err)
+		}
+		current = next
+	}
+
+	switch parent := current.(type) {
+	case map[string]interface{}:
+		parent[last] = newValue
+	case []map[string]interface{}:
+		index, err := parseIndex(last, len(parent))
+		if err != nil {
+			return err
+		}
+		element, ok := newValue.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("elements of %s need to be structures", strings.Join(parentSegments, "."))
+		}
+		parent[index] = element
+	case []interface{}:
+		index, err := parseIndex(last, len(parent))
+		if err != nil {
+			return err
+		}
+		parent[index] = newValue
+	default:
+		return fmt.Errorf("%s is no structure or array", strings.Join(parentSegments, "."))
+	}
+	return nil
+}
+
+// unsetPath removes a key from its structure, array elements can not be unset
+func unsetPath(doc map[string]interface{}, segments []string) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no path given")
+	}
+	parent, err := getPath(doc, segments[:len(segments)-1])
+	if err != nil {
+		return err
+	}
+	m, ok := parent.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s is no structure, array elements can not be unset", strings.Join(segments[:len(segments)-1], "."))
+	}
+	last := segments[len(segments)-1]
+	if _, exists := m[last]; !exists {
+		return fmt.Errorf("%s is not set", strings.Join(segments, "."))
+	}
+	delete(m, last)
+	return nil
+}
+
+// descriptorFor returns the schema of the value at a path
+func descriptorFor(schema *cs.ValueTypeDescriptor, segments []string) (*cs.ValueTypeDescriptor, error) {
+	vtd := schema
+	for i, segment := range segments {
+		if vtd == nil {
+			break
+		}
+		switch vtd.Type {
+		case cs.ValueType_Structure:
+			vtd = vtd.StructureSubtypes[segment]
+		case cs.ValueType_StructureArray:
+			if _, err := strconv.Atoi(segment); err != nil {
+				return nil, fmt.Errorf("%s is an array, use an index", strings.Join(segments[:i], "."))
+			}
+			vtd = &cs.ValueTypeDescriptor{Type: cs.ValueType_Structure, StructureSubtypes: vtd.StructureSubtypes}
+		case cs.ValueType_Array:
+			if _, err := strconv.Atoi(segment); err != nil {
+				return nil, fmt.Errorf("%s is an array, use an index", strings.Join(segments[:i], "."))
+			}
+			vtd = vtd.ArraySubType
+		case cs.ValueType_MultiSelect:
+			if _, err := strconv.Atoi(segment); err != nil {
+				return nil, fmt.Errorf("%s is an array, use an index", strings.Join(segments[:i], "."))
+			}
+			vtd = &cs.ValueTypeDescriptor{Type: cs.ValueType_Select, Options: vtd.Options}
+		default:
+			return nil, fmt.Errorf("%s is no structure or array", strings.Join(segments[:i], "."))
+		}
+	}
+	if vtd == nil {
+		return nil, fmt.Errorf("%s does not exist in schema", strings.Join(segments, "."))
+	}
+	return vtd, nil
+}
+
+// parseValue converts a command line argument into the type described by the schema
+func parseValue(vtd *cs.ValueTypeDescriptor, raw string) (interface{}, error) {
+	switch vtd.Type {
+	case cs.ValueType_Integer, cs.ValueType_Port, cs.ValueType_UniqueInc, cs.ValueType_IntegerSelect:
+		num, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is no integer", raw)
+		}
+		return num, nil
+	case cs.ValueType_Float:
+		num, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is no number", raw)
+		}
+		return num, nil
+	case cs.ValueType_Checkbox:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is no boolean", raw)
+		}
+		return b, nil
+	case cs.ValueType_String, cs.ValueType_IP, cs.ValueType_Password, cs.ValueType_Select:
+		return raw, nil
+	case cs.ValueType_Array, cs.ValueType_MultiSelect:
+		if !strings.HasPrefix(strings.TrimSpace(raw), "[") {
+			elementType := vtd.ArraySubType
+			if vtd.Type == cs.ValueType_MultiSelect {
+				elementType = &cs.ValueTypeDescriptor{Type: cs.ValueType_Select, Options: vtd.Options}
+			}
+			values := make([]interface{}, 0)
+			for _, element := range strings.Split(raw, ",") {
+				if element == "" {
+					continue
+				}
+				value, err := parseValue(elementType, strings.TrimSpace(element))
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			return values, nil
+		}
+	}
+	return parseJSON(raw)
+}
+
+// parseJSON decodes structures and arrays given as JSON, ValidateConfig converts the numbers to integers where needed
+func parseJSON(raw string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return nil, fmt.Errorf("on decoding json value: %w", err)
+	}
+	if elements, ok := value.([]interface{}); ok {
+		structures := make([]map[string]interface{}, 0, len(elements))
+		for _, element := range elements {
+			structure, ok := element.(map[string]interface{})
+			if !ok {
+				return value, nil
+			}
+			structures = append(structures, structure)
+		}
+		if len(structures) > 0 {
+			return structures, nil
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// baseDeviceFields are the fields provided by config.BaseDeviceConfig
+var baseDeviceFields = []string{"Active", "Name", "DeviceID", "ModelID", "Description"}
+
+type structDef struct {
+	name       string
+	fields     map[string]*cs.ValueTypeDescriptor
+	embedBase  bool
+	sourcePath string
+}
+
+type generator struct {
+	packageName  string
+	usedNames    map[string]bool
+	structs      []*structDef
+	importConfig bool
+	warnings     []string
+}
+
+func newGenerator(packageName string) *generator {
+	return &generator{packageName: packageName, usedNames: make(map[string]bool)}
+}
+
+func (g *generator) warnf(format string, args ...interface{}) {
+	g.warnings = append(g.warnings, fmt.Sprintf(format, args...))
+}
+
+func (g *generator) generate(schema *cs.ValueTypeDescriptor, rootName string) ([]byte, error) {
+	if schema.Type != cs.ValueType_Structure {
+		return nil, fmt.Errorf("schema root is no structure")
+	}
+
+	g.addStruct(rootName, schema.StructureSubtypes, false, "")
+
+	var body bytes.Buffer
+	for i := 0; i < len(g.structs); i++ { // structs are appended while generating
+		if err := g.writeStruct(&body, g.structs[i]); err != nil {
+			return nil, err
+		}
+	}
+	if models := findModels(schema); len(models) > 0 {
+		g.writeModels(&body, rootName+"Models", models)
+	}
+	if presets := findPresets(schema, ""); len(presets) > 0 {
+		g.writePresets(&body, rootName+"Presets", presets)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by schemagen. DO NOT EDIT.\n\npackage %s\n\n", g.packageName)
+	if g.importConfig {
+		buf.WriteString("import config \"github.com/SKAARHOJ/ibeam-lib-config\"\n\n")
+	}
+	buf.Write(body.Bytes())
+
+	code, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("on formatting generated code: %w", err)
+	}
+	return code, nil
+}
+
+func (g *generator) addStruct(name string, fields map[string]*cs.ValueTypeDescriptor, embedBase bool, sourcePath string) string {
+	typeName := name
+	for i := 2; g.usedNames[typeName]; i++ {
+		typeName = name + strconv.Itoa(i)
+	}
+	g.usedNames[typeName] = true
+	g.structs = append(g.structs, &structDef{name: typeName, fields: fields, embedBase: embedBase, sourcePath: sourcePath})
+	return typeName
+}
+
+func (g *generator) writeStruct(buf *bytes.Buffer, def *structDef) error {
+	names := make([]string, 0, len(def.fields))
+	for name, vtd := range def.fields {
+		if vtd == nil {
+			continue
+		}
+		if def.embedBase && containsString(baseDeviceFields, name) {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, oj := def.fields[names[i]].Order, def.fields[names[j]].Order
+		if oi != oj {
+			return oi < oj
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(buf, "type %s struct {\n", def.name)
+	if def.embedBase {
+		buf.WriteString("\tconfig.BaseDeviceConfig\n")
+	}
+	for _, name := range names {
+		vtd := def.fields[name]
+		fieldPath := strings.TrimPrefix(def.sourcePath+"."+name, ".")
+
+		goType, err := g.goType(name, vtd, fieldPath)
+		if err != nil {
+			return err
+		}
+
+		fieldName := name
+		var tags []string
+		if !isExportedIdentifier(name) {
+			fieldName = exportedIdentifier(name)
+			g.warnf("field %s is no exported Go identifier, using %s (GetSchema will use the Go name)", fieldPath, fieldName)
+			tags = append(tags, tag("toml", name), tag("json", name))
+		}
+		tags = append(tags, g.tags(vtd)...)
+
+		fmt.Fprintf(buf, "\t%s %s", fieldName, goType)
+		if len(tags) > 0 {
+			tagString := strings.Join(tags, " ")
+			if strings.Contains(tagString, "`") {
+				buf.WriteString(" " + strconv.Quote(tagString))
+			} else {
+				buf.WriteString(" `" + tagString + "`")
+			}
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}\n\n")
+	return nil
+}
+
+// writeModels writes the model catalog of the schema as a variable, models can not be expressed by struct tags
+func (g *generator) writeModels(buf *bytes.Buffer, name string, models []cs.Model) {
+	g.importConfig = true
+	fmt.Fprintf(buf, "// %s is the model catalog of the schema, register it with config.SetModels(%s) before Load\n", name, name)
+	fmt.Fprintf(buf, "var %s = []config.Model{\n", name)
+	for _, model := range models {
+		fmt.Fprintf(buf, "\t{ID: %d, Name: %s", model.ID, strconv.Quote(model.Name))
+		if model.Description != "" {
+			fmt.Fprintf(buf, ", Description: %s", strconv.Quote(model.Description))
+		}
+		if len(model.Capabilities) > 0 {
+			capabilities := make([]string, len(model.Capabilities))
+			for i, capability := range model.Capabilities {
+				capabilities[i] = strconv.Quote(capability)
+			}
+			fmt.Fprintf(buf, ", Capabilities: []string{%s}", strings.Join(capabilities, ", "))
+		}
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n\n")
+}
+
+// findModels returns the first model catalog found in a schema
+func findModels(vtd *cs.ValueTypeDescriptor) []cs.Model {
+	if vtd == nil {
+		return nil
+	}
+	if len(vtd.Models) > 0 {
+		return vtd.Models
+	}
+	names := make([]string, 0, len(vtd.StructureSubtypes))
+	for name := range vtd.StructureSubtypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if models := findModels(vtd.StructureSubtypes[name]); len(models) > 0 {
+			return models
+		}
+	}
+	return nil
+}
+
+// writePresets writes the presets of the schema by field path as a variable, presets can not be expressed by struct tags
+func (g *generator) writePresets(buf *bytes.Buffer, name string, presets map[string][]cs.Preset) {
+	g.importConfig = true
+	paths := make([]string, 0, len(presets))
+	for path := range presets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintf(buf, "// %s are the presets of the schema by field path, register them with config.RegisterPreset before Load\n", name)
+	fmt.Fprintf(buf, "var %s = map[string][]config.Preset{\n", name)
+	for _, path := range paths {
+		fmt.Fprintf(buf, "\t%s: {\n", strconv.Quote(path))
+		for _, preset := range presets[path] {
+			fmt.Fprintf(buf, "\t\t{Name: %s", strconv.Quote(preset.Name))
+			if preset.Description != "" {
+				fmt.Fprintf(buf, ", Description: %s", strconv.Quote(preset.Description))
+			}
+			fields := make([]string, 0, len(preset.Values))
+			for field := range preset.Values {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			values := make([]string, len(fields))
+			for i, field := range fields {
+				values[i] = fmt.Sprintf("%s: %#v", strconv.Quote(field), preset.Values[field])
+			}
+			fmt.Fprintf(buf, ", Values: map[string]interface{}{%s}},\n", strings.Join(values, ", "))
+		}
+		buf.WriteString("\t},\n")
+	}
+	buf.WriteString("}\n\n")
+}
+
+// findPresets returns the presets of all structure arrays in a schema by their field path
+func findPresets(vtd *cs.ValueTypeDescriptor, path string) map[string][]cs.Preset {
+	presets := make(map[string][]cs.Preset)
+	if vtd == nil {
+		return presets
+	}
+	if len(vtd.Presets) > 0 {
+		presets[path] = vtd.Presets
+	}
+	for name, sub := range vtd.StructureSubtypes {
+		for subPath, subPresets := range findPresets(sub, strings.TrimPrefix(path+"."+name, ".")) {
+			presets[subPath] = subPresets
+		}
+	}
+	return presets
+}
+
+func (g *generator) goType(name string, vtd *cs.ValueTypeDescriptor, fieldPath string) (string, error) {
+	switch vtd.Type {
+	case cs.ValueType_Integer, cs.ValueType_IntegerSelect:
+		return "int", nil
+	case cs.ValueType_Float:
+		return "float64", nil
+	case cs.ValueType_String, cs.ValueType_IP, cs.ValueType_Password, cs.ValueType_Select:
+		return "string", nil
+	case cs.ValueType_Port:
+		return "uint16", nil
+	case cs.ValueType_UniqueInc:
+		return "uint32", nil
+	case cs.ValueType_Checkbox:
+		return "bool", nil
+	case cs.ValueType_MultiSelect:
+		return "[]string", nil
+	case cs.ValueType_Structure:
+		return g.addStruct(exportedIdentifier(name)+"Config", vtd.StructureSubtypes, false, fieldPath), nil
+	case cs.ValueType_StructureArray:
+		embedBase := hasBaseDeviceFields(vtd.StructureSubtypes)
+		if !embedBase && (containsString(vtd.DispatchOptions, "devices") || strings.ToLower(name) == "devices") {
+			g.warnf("device array %s does not contain the fields of config.BaseDeviceConfig, embedding it anyway", fieldPath)
+			embedBase = true
+		}
+		if embedBase {
+			g.importConfig = true
+		}
+		return "[]" + g.addStruct(exportedIdentifier(name)+"Config", vtd.StructureSubtypes, embedBase, fieldPath), nil
+	case cs.ValueType_Array:
+		if vtd.ArraySubType == nil {
+			return "", fmt.Errorf("array %s has no subtype", fieldPath)
+		}
+		elemType, err := g.goType(name, vtd.ArraySubType, fieldPath)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elemType, nil
+	}
+
+	g.warnf("field %s has unknown type %d, using string", fieldPath, vtd.Type)
+	return "string", nil
+}
+
+// tags returns the struct tags that make GetSchema reproduce a descriptor
+func (g *generator) tags(vtd *cs.ValueTypeDescriptor) []string {
+	var tags []string
+	if vtd.Label != "" {
+		tags = append(tags, tag("ibLabel", vtd.Label))
+	}
+	if vtd.Description != "" {
+		tags = append(tags, tag("ibDescription", vtd.Description))
+	}
+
+	if vtd.Type != cs.ValueType_Structure { // Structures only carry label, description, required and hidden
+		leaf := vtd
+		for leaf.Type == cs.ValueType_Array && leaf.ArraySubType != nil {
+			leaf = leaf.ArraySubType // Tags of arrays are applied to their elements
+		}
+
+		if len(leaf.Options) > 0 && (leaf.Type == cs.ValueType_Select || leaf.Type == cs.ValueType_IntegerSelect || leaf.Type == cs.ValueType_MultiSelect) {
+			tags = append(tags, tag("ibOptions", strings.Join(leaf.Options, ",")))
+		}
+		switch leaf.Type {
+		case cs.ValueType_Port:
+			tags = append(tags, tag("ibValidate", "port"))
+		case cs.ValueType_IP:
+			tags = append(tags, tag("ibValidate", "ip"))
+		case cs.ValueType_Password:
+			tags = append(tags, tag("ibValidate", "password"))
+		case cs.ValueType_UniqueInc:
+			tags = append(tags, tag("ibValidate", "unique_inc"))
+		}
+		if vtd.Order != 0 {
+			tags = append(tags, tag("ibOrder", strconv.Itoa(vtd.Order)))
+		}
+		if len(vtd.DispatchOptions) > 0 {
+			tags = append(tags, tag("ibDispatch", strings.Join(vtd.DispatchOptions, ",")))
+		}
+		if def := defaultTag(leaf); def != "" {
+			tags = append(tags, tag("ibDefault", def))
+		}
+		if len(leaf.DefaultOnModel) > 0 {
+			tags = append(tags, tag("ibDefaultOnModel", defaultOnModelTag(leaf)))
+		}
+	}
+
+	if vtd.Required != "" {
+		tags = append(tags, tag("ibRequired", vtd.Required))
+	}
+	if vtd.Hidden != "" {
+		tags = append(tags, tag("ibHidden", vtd.Hidden))
+	}
+	if vtd.Secret {
+		tags = append(tags, tag("ibSecret", "true"))
+	}
+	if vtd.MinItems > 0 {
+		tags = append(tags, tag("ibMinItems", strconv.Itoa(vtd.MinItems)))
+	}
+	if vtd.MaxItems > 0 {
+		tags = append(tags, tag("ibMaxItems", strconv.Itoa(vtd.MaxItems)))
+	}
+	if vtd.Type != cs.ValueType_Structure {
+		if vtd.Headline != "" {
+			tags = append(tags, tag("ibHeadline", vtd.Headline))
+		}
+		if len(vtd.OnlyOnModel) > 0 {
+			tags = append(tags, tag("ibOnlyOnModel", joinInts(vtd.OnlyOnModel)))
+		}
+		if len(vtd.NotOnModel) > 0 {
+			tags = append(tags, tag("ibNotOnModel", joinInts(vtd.NotOnModel)))
+		}
+	}
+	return tags
+}
+
+func defaultTag(vtd *cs.ValueTypeDescriptor) string {
+	switch def := vtd.Default.(type) {
+	case nil:
+		return ""
+	case string:
+		return def
+	case bool:
+		if def {
+			return "true"
+		}
+		return ""
+	case float64:
+		if vtd.Type == cs.ValueType_Float {
+			return strconv.FormatFloat(def, 'f', -1, 64)
+		}
+		return strconv.FormatInt(int64(def), 10)
+	case []interface{}:
+		options := make([]string, len(def))
+		for i, option := range def {
+			options[i] = fmt.Sprint(option)
+		}
+		return strings.Join(options, ",")
+	case []string: // Schemas from GetSchema, decoded schema files hold []interface{}
+		return strings.Join(def, ",")
+	}
+	return fmt.Sprint(vtd.Default)
+}
+
+func defaultOnModelTag(vtd *cs.ValueTypeDescriptor) string {
+	models := make([]int, 0, len(vtd.DefaultOnModel))
+	for model := range vtd.DefaultOnModel {
+		models = append(models, model)
+	}
+	sort.Ints(models)
+
+	entries := make([]string, len(models))
+	for i, model := range models {
+		def := defaultTag(&cs.ValueTypeDescriptor{Type: vtd.Type, Default: vtd.DefaultOnModel[model]})
+		if def == "" && vtd.DefaultOnModel[model] == false {
+			def = "false"
+		}
+		entries[i] = strconv.Itoa(model) + "=" + def
+	}
+	return strings.Join(entries, ",")
+}
+
+func hasBaseDeviceFields(fields map[string]*cs.ValueTypeDescriptor) bool {
+	for _, name := range baseDeviceFields {
+		if fields[name] == nil {
+			return false
+		}
+	}
+	return true
+}
+
+func tag(key, value string) string {
+	return key + ":" + strconv.Quote(value)
+}
+
+func joinInts(ints []int) string {
+	all := make([]string, len(ints))
+	for i, num := range ints {
+		all[i] = strconv.Itoa(num)
+	}
+	return strings.Join(all, ",")
+}
+
+func isExportedIdentifier(name string) bool {
+	return token.IsIdentifier(name) && token.IsExported(name)
+}
+
+func exportedIdentifier(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	identifier := b.String()
+	if identifier == "" || !unicode.IsLetter([]rune(identifier)[0]) {
+		identifier = "X" + identifier
+	}
+	return identifier
+}
+
+func containsString(all []string, one string) bool {
+	for _, s := range all {
+		if s == one {
+			return true
+		}
+	}
+	return false
+}
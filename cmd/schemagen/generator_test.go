@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	config "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+type testCamera struct {
+	config.BaseDeviceConfig
+	IP       string   `ibValidate:"ip" ibDispatch:"deviceip" ibOrder:"30"`
+	Port     uint16   `ibValidate:"port" ibDispatch:"port" ibDefault:"9910" ibDefaultOnModel:"7=52381,9=1259"`
+	Tally    bool     `ibDefault:"true" ibDefaultOnModel:"9=false"`
+	Protocol string   `ibOptions:"VISCA,NDI" ibDefault:"VISCA" ibDefaultOnModel:"9=NDI"`
+	Inputs   []string `ibOptions:"SDI1,SDI2,HDMI" ibDefault:"SDI1,HDMI"`
+	Password string   `ibValidate:"password" ibOnlyOnModel:"7"`
+}
+
+type testNetwork struct {
+	Timeout float64 `ibDefault:"1.5" ibLabel:"Timeout (s)"`
+	Retry   struct {
+		Count int    `ibDefault:"3"`
+		Mode  string `ibOptions:"linear,exponential" ibRequired:"Choose a retry mode"`
+	}
+}
+
+type testConfig struct {
+	PollMs   int          `ibOptions:"50,100,250" ibDefault:"100" ibDescription:"Polling interval"`
+	Network  testNetwork  `ibLabel:"Network settings"`
+	Cameras  []testCamera `ibDispatch:"devices" ibMaxItems:"8"`
+	Channels []int        `ibHidden:"true"`
+}
+
+func TestGenerate(t *testing.T) {
+	original, err := json.Marshal(config.GetSchema(&testConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema cs.ValueTypeDescriptor // Decoded like the schema file read by main
+	if err := json.Unmarshal(original, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	gen := newGenerator("main")
+	code, err := gen.generate(&schema, "Config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gen.warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", gen.warnings)
+	}
+	if direct, err := newGenerator("main").generate(config.GetSchema(&testConfig{}), "Config"); err != nil || string(direct) != string(code) {
+		t.Errorf("expected the same code for the schema of GetSchema, got %v:\n%s", err, direct)
+	}
+
+	expected := []string{
+		"import config \"github.com/SKAARHOJ/ibeam-lib-config\"",
+		"type Config struct",
+		"PollMs   int            `ibDescription:\"Polling interval\" ibOptions:\"50,100,250\" ibDefault:\"100\"`",            // Integer select
+		"Protocol string `ibOptions:\"VISCA,NDI\" ibDefault:\"VISCA\" ibDefaultOnModel:\"9=NDI\"`",                           // Select
+		"Inputs   []string `ibOptions:\"SDI1,SDI2,HDMI\" ibDefault:\"SDI1,HDMI\"`",                                           // Multi select
+		"Port     uint16   `ibValidate:\"port\" ibDispatch:\"port\" ibDefault:\"9910\" ibDefaultOnModel:\"7=52381,9=1259\"`", // Structure array defaults
+		"Tally    bool     `ibDefault:\"true\" ibDefaultOnModel:\"9=false\"`",                                                // False defaults on model
+		"IP       string   `ibValidate:\"ip\" ibOrder:\"30\" ibDispatch:\"deviceip\"`",                                       // Dispatch
+		"Cameras  []CamerasConfig `ibDispatch:\"devices\" ibMaxItems:\"8\"`",                                                 // Device array
+		"config.BaseDeviceConfig", // Embedded base fields
+		"Retry   RetryConfig",     // Nested structure
+		"Mode  string `ibOptions:\"linear,exponential\" ibRequired:\"Choose a retry mode\"`", // Required
+		"Password string   `ibValidate:\"password\" ibOnlyOnModel:\"7\"`",                    // Model filter
+		"Channels []int            `ibHidden:\"true\"`",                                      // Plain array
+	}
+	for _, line := range expected {
+		if !containsCode(string(code), line) {
+			t.Errorf("expected %s in generated code:\n%s", line, code)
+		}
+	}
+}
+
+// TestGeneratedCodeReproducesSchema compiles the generated code with the go tool, it is left out with -short
+func TestGeneratedCodeReproducesSchema(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling generated code is slow")
+	}
+	original, err := json.Marshal(config.GetSchema(&testConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := newGenerator("main").generate(config.GetSchema(&testConfig{}), "Config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generated := schemaOfGenerated(t, code, "Config")
+	var want, got interface{}
+	if err := json.Unmarshal(original, &want); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(generated, &got); err != nil {
+		t.Fatalf("on decoding schema of generated code: %v\n%s", err, generated)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("expected generated code to reproduce the schema\nwant: %s\ngot:  %s", original, generated)
+	}
+}
+
+func TestGenerateWarnings(t *testing.T) {
+	schema := &cs.ValueTypeDescriptor{Type: cs.ValueType_Structure, StructureSubtypes: map[string]*cs.ValueTypeDescriptor{
+		"poll-ms": {Type: cs.ValueType_Integer},
+		"Devices": {Type: cs.ValueType_StructureArray, StructureSubtypes: map[string]*cs.ValueTypeDescriptor{
+			"IP": {Type: cs.ValueType_IP},
+		}},
+	}}
+
+	gen := newGenerator("config")
+	code, err := gen.generate(schema, "Config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gen.warnings) != 2 {
+		t.Errorf("expected warnings for the field name and the missing base fields, got %v", gen.warnings)
+	}
+	for _, line := range []string{"PollMs int `toml:\"poll-ms\" json:\"poll-ms\"`", "config.BaseDeviceConfig"} {
+		if !containsCode(string(code), line) {
+			t.Errorf("expected %s in generated code:\n%s", line, code)
+		}
+	}
+
+	if _, err := newGenerator("config").generate(&cs.ValueTypeDescriptor{Type: cs.ValueType_Array}, "Config"); err == nil {
+		t.Errorf("expected error for a schema root that is no structure")
+	}
+}
+
+// containsCode looks for a line of code ignoring the alignment of gofmt
+func containsCode(code, line string) bool {
+	return strings.Contains(strings.Join(strings.Fields(code), " "), strings.Join(strings.Fields(line), " "))
+}
+
+// schemaOfGenerated compiles the generated code against this module and returns the schema GetSchema creates for the root type
+func schemaOfGenerated(t *testing.T, code []byte, rootName string) []byte {
+	t.Helper()
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found, can not compile generated code")
+	}
+	root, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	goSum, err := os.ReadFile(filepath.Join(root, "go.sum"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":       "module schemagentest\n\ngo 1.15\n\nrequire github.com/SKAARHOJ/ibeam-lib-config v0.0.0\n\nreplace github.com/SKAARHOJ/ibeam-lib-config => " + root + "\n",
+		"go.sum":       string(goSum),
+		"generated.go": string(code),
+		"main.go": `package main
+
+import (
+	"encoding/json"
+	"os"
+
+	config "github.com/SKAARHOJ/ibeam-lib-config"
+)
+
+func main() {
+	data, err := json.Marshal(config.GetSchema(&` + rootName + `{}))
+	if err != nil {
+		panic(err)
+	}
+	os.Stdout.Write(data)
+}
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(goBin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOPROXY=off")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("on running generated code: %v\n%s", err, stderr.String())
+	}
+	return out
+}
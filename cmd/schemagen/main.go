@@ -0,0 +1,63 @@
+// Command schemagen generates Go config structures with ib* struct tags from a <core>.schema.json file
+//
+//	schemagen [-package name] [-type Config] [-o config.go] core-example.schema.json
+//
+// Passing the generated root type to config.GetSchema reproduces the input schema
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+func main() {
+	packageName := flag.String("package", "main", "package name of the generated file")
+	typeName := flag.String("type", "Config", "name of the generated root type")
+	output := flag.String("o", "", "output file, defaults to stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <schema.json>\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	data, err := os.ReadFile(flag.Arg(0))
+	if err != nil {
+		fail(err)
+	}
+
+	var schema cs.ValueTypeDescriptor
+	if err := json.Unmarshal(data, &schema); err != nil {
+		fail(fmt.Errorf("on decoding schema: %w", err))
+	}
+
+	gen := newGenerator(*packageName)
+	code, err := gen.generate(&schema, *typeName)
+	if err != nil {
+		fail(err)
+	}
+	for _, warning := range gen.warnings {
+		fmt.Fprintln(os.Stderr, "warning:", warning)
+	}
+
+	if *output == "" {
+		os.Stdout.Write(code)
+		return
+	}
+	if err := os.WriteFile(*output, code, 0644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,421 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/s00500/env_logger"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat is the format of a config file, it is also used as the extension of the file
+type ConfigFormat = string
+
+// ConfigFormats supported out of the box
+const (
+	ConfigFormat_TOML ConfigFormat = "toml"
+	ConfigFormat_JSON ConfigFormat = "json"
+	ConfigFormat_YAML ConfigFormat = "yaml"
+)
+
+// Codec reads and writes config files of one format.
+// Keys are the same for every format, they follow the field names and toml tags of the config structure
+type Codec interface {
+	Marshal(doc map[string]interface{}) ([]byte, error)
+	Unmarshal(data []byte) (map[string]interface{}, error)
+}
+
+// LineLocator is implemented by codecs that can tell the line of every value in a file, used for the provenance of values.
+// Lines are keyed by the path of the value (eg. "Devices.0.Port")
+type LineLocator interface {
+	Lines(data []byte) (map[string]int, error)
+}
+
+var configFormat ConfigFormat = "" // Empty detects the format from the existing config file
+
+var codecs = map[ConfigFormat]Codec{
+	ConfigFormat_TOML: tomlCodec{},
+	ConfigFormat_JSON: jsonCodec{},
+	ConfigFormat_YAML: yamlCodec{},
+}
+var codecsMu sync.Mutex
+
+// SetConfigFormat chooses the format of the config file. If the config file of the core exists in another format, Load converts it once.
+// Without a format set (or after setting ""), the format of the existing config file is used, new config files are written as toml
+func SetConfigFormat(format ConfigFormat) {
+	if format != "" && GetCodec(format) == nil {
+		log.Fatalf("no codec registered for config format %q", format)
+	}
+	configFormat = format
+}
+
+// RegisterCodec adds support for a config format, or replaces the codec of one of the default formats
+func RegisterCodec(format ConfigFormat, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[format] = codec
+}
+
+// GetCodec returns the codec of a config format, or nil if there is none
+func GetCodec(format ConfigFormat) Codec {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	return codecs[format]
+}
+
+// ConvertConfig converts the existing config file of the core to another format once. The old file is kept with a .bak extension
+func ConvertConfig(format ConfigFormat) error {
+	if coreName == "" {
+		log.Panic("no corename set")
+	}
+	from, exists := detectConfigFormat()
+	if !exists {
+		return fmt.Errorf("no config file to convert")
+	}
+	if from == format {
+		return nil
+	}
+	if err := convertConfigFile(from, format); err != nil {
+		return err
+	}
+	recordConverted(configFileName(coreName, format))
+	return nil
+}
+
+func convertConfigFile(from, to ConfigFormat) error {
+	fromFile := configFileName(coreName, from)
+	data, err := os.ReadFile(fromFile)
+	if err != nil {
+		return err
+	}
+	doc, err := DecodeDocument(from, data)
+	if err != nil {
+		return err
+	}
+	data, err = EncodeDocument(to, doc)
+	if err != nil {
+		return err
+	}
+
+	if err := writeUserFile(configFileName(coreName, to), data); err != nil {
+		return err
+	}
+	log.Infof("converted config from %s to %s", from, to)
+	return os.Rename(fromFile, fromFile+".bak")
+}
+
+// activeConfigFormat returns the format to load and save the config file in, converting an existing file of another format if a format has been set
+func activeConfigFormat() (ConfigFormat, error) {
+	detected, exists := detectConfigFormat()
+	if configFormat == "" {
+		return detected, nil
+	}
+	if exists && detected != configFormat {
+		if err := convertConfigFile(detected, configFormat); err != nil {
+			return "", fmt.Errorf("on converting config: %w", err)
+		}
+	}
+	return configFormat, nil
+}
+
+// detectConfigFormat returns the format of the existing config file, toml is checked first
+func detectConfigFormat() (ConfigFormat, bool) {
+	if configFormat != "" {
+		if _, err := os.Stat(configFileName(coreName, configFormat)); err == nil {
+			return configFormat, true
+		}
+	}
+	for _, format := range codecFormats() {
+		if _, err := os.Stat(configFileName(coreName, format)); err == nil {
+			return format, true
+		}
+	}
+	return ConfigFormat_TOML, false
+}
+
+// codecFormats returns all formats with a registered codec, toml first and then sorted by name
+func codecFormats() []ConfigFormat {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	formats := make([]ConfigFormat, 0, len(codecs))
+	for format := range codecs {
+		if format != ConfigFormat_TOML {
+			formats = append(formats, format)
+		}
+	}
+	sort.Strings(formats)
+	return append([]ConfigFormat{ConfigFormat_TOML}, formats...)
+}
+
+// configFileName returns the path of a config file in a format
+func configFileName(filename string, format ConfigFormat) string {
+	return getBaseFileName(filename) + "." + format
+}
+
+// DecodeDocument decodes a config file of a format into a generic document, numbers are decoded as int64 or float64 like in toml
+func DecodeDocument(format ConfigFormat, data []byte) (map[string]interface{}, error) {
+	codec := GetCodec(format)
+	if codec == nil {
+		return nil, fmt.Errorf("no codec registered for config format %q", format)
+	}
+	doc, err := codec.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("on decoding %s: %w", format, err)
+	}
+	return normalizeDocument(doc), nil
+}
+
+// EncodeDocument encodes a generic document as a config file of a format
+func EncodeDocument(format ConfigFormat, doc map[string]interface{}) ([]byte, error) {
+	codec := GetCodec(format)
+	if codec == nil {
+		return nil, fmt.Errorf("no codec registered for config format %q", format)
+	}
+	return codec.Marshal(normalizeDocument(doc))
+}
+
+// PatchDocument encodes a generic document like EncodeDocument, but keeps comments, key order and formatting of the existing file
+// for TOML, where only the changed values are replaced. The whole document is encoded if it changed shape or the format is no TOML
+func PatchDocument(format ConfigFormat, existing []byte, doc map[string]interface{}) ([]byte, error) {
+	data, err := EncodeDocument(format, doc)
+	if err != nil || format != ConfigFormat_TOML || len(existing) == 0 {
+		return data, err
+	}
+	if patched, ok := patchTOML(existing, data); ok {
+		return patched, nil
+	}
+	return data, nil
+}
+
+// toTOML converts a config file to toml, so every format is decoded into the structure the same way
+func toTOML(format ConfigFormat, data []byte) ([]byte, error) {
+	if format == ConfigFormat_TOML {
+		return data, nil
+	}
+	doc, err := DecodeDocument(format, data)
+	if err != nil {
+		return nil, err
+	}
+	return tomlCodec{}.Marshal(doc)
+}
+
+// fromTOML converts an encoded config to another format
+func fromTOML(format ConfigFormat, data []byte) ([]byte, error) {
+	if format == ConfigFormat_TOML {
+		return data, nil
+	}
+	doc, err := tomlCodec{}.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeDocument(format, doc)
+}
+
+// normalizeDocument converts decoded values to the types the toml encoder expects: json numbers to int64 or float64, arrays of objects to arrays of tables, nulls are dropped
+func normalizeDocument(doc map[string]interface{}) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(doc))
+	for key, value := range doc {
+		if value = normalizeValue(value); value != nil {
+			normalized[key] = value
+		}
+	}
+	return normalized
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case int:
+		return int64(v)
+	case map[string]interface{}:
+		return normalizeDocument(v)
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			converted[fmt.Sprint(key)] = element
+		}
+		return normalizeDocument(converted)
+	case []interface{}:
+		tables := make([]map[string]interface{}, 0, len(v))
+		elements := make([]interface{}, 0, len(v))
+		for _, element := range v {
+			element = normalizeValue(element)
+			if table, ok := element.(map[string]interface{}); ok {
+				tables = append(tables, table)
+			}
+			elements = append(elements, element)
+		}
+		if len(v) > 0 && len(tables) == len(v) {
+			return tables
+		}
+		return elements
+	}
+	return value
+}
+
+type tomlCodec struct{}
+
+func (tomlCodec) Marshal(doc map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(doc); err != nil {
+		return nil, fmt.Errorf("on encoding toml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (tomlCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("on decoding toml: %w", err)
+	}
+	return doc, nil
+}
+
+func (tomlCodec) Lines(data []byte) (map[string]int, error) {
+	doc := string(data)
+	entries, tables, err := scanTOML(doc)
+	if err != nil {
+		return nil, err
+	}
+	lines := make(map[string]int, len(entries)+len(tables))
+	for path, table := range tables {
+		if table.headerStart >= 0 {
+			lines[strings.ReplaceAll(path, pathSeparator, ".")] = strings.Count(doc[:table.headerStart], "\n") + 1
+		}
+	}
+	for _, entry := range entries {
+		lines[strings.ReplaceAll(entry.path, pathSeparator, ".")] = strings.Count(doc[:entry.start], "\n") + 1
+	}
+	return lines, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(doc map[string]interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func (jsonCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber() // Keep integers exact
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (jsonCodec) Lines(data []byte) (map[string]int, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	lines := make(map[string]int)
+	line := func() int {
+		return bytes.Count(data[:decoder.InputOffset()], []byte("\n")) + 1
+	}
+
+	// walk reads the value at path, lines are taken after reading the key or the first token of the value
+	var walk func(path string) error
+	walk = func(path string) error {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if path != "" {
+			if _, ok := lines[path]; !ok {
+				lines[path] = line()
+			}
+		}
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				lines[joinPath(path, fmt.Sprint(key))] = line()
+				if err := walk(joinPath(path, fmt.Sprint(key))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(joinPath(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+		return err
+	}
+	return lines, walk("")
+}
+
+type yamlCodec struct{}
+
+func (yamlCodec) Marshal(doc map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (yamlCodec) Unmarshal(data []byte) (map[string]interface{}, error) {
+	doc := make(map[string]interface{})
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (yamlCodec) Lines(data []byte) (map[string]int, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	lines := make(map[string]int)
+
+	var walk func(node *yaml.Node, path string)
+	walk = func(node *yaml.Node, path string) {
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, content := range node.Content {
+				walk(content, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key := joinPath(path, node.Content[i].Value)
+				lines[key] = node.Content[i].Line
+				walk(node.Content[i+1], key)
+			}
+		case yaml.SequenceNode:
+			for i, content := range node.Content {
+				lines[joinPath(path, strconv.Itoa(i))] = content.Line
+				walk(content, joinPath(path, strconv.Itoa(i)))
+			}
+		}
+	}
+	walk(&root, "")
+	return lines, nil
+}
@@ -0,0 +1,252 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// SchemaChangeKind classifies a difference between two schema versions
+type SchemaChangeKind = string
+
+// SchemaChangeKinds reported by CompareSchemas
+const (
+	SchemaChange_FieldAdded         SchemaChangeKind = "field added"
+	SchemaChange_RequiredFieldAdded SchemaChangeKind = "required field added"
+	SchemaChange_FieldRemoved       SchemaChangeKind = "field removed"
+	SchemaChange_TypeChanged        SchemaChangeKind = "type changed"
+	SchemaChange_OptionAdded        SchemaChangeKind = "option added"
+	SchemaChange_OptionRemoved      SchemaChangeKind = "option removed"
+	SchemaChange_BecameRequired     SchemaChangeKind = "became required"
+	SchemaChange_DefaultChanged     SchemaChangeKind = "default changed"
+	SchemaChange_DispatchChanged    SchemaChangeKind = "dispatch changed"
+	SchemaChange_UniqueIncMoved     SchemaChangeKind = "unique_inc moved"
+	SchemaChange_ModelAdded         SchemaChangeKind = "model added"
+	SchemaChange_ModelRemoved       SchemaChangeKind = "model removed"
+	SchemaChange_ItemLimitsChanged  SchemaChangeKind = "item limits changed"
+	SchemaChange_SchemaAdded        SchemaChangeKind = "schema added"
+	SchemaChange_SchemaRemoved      SchemaChangeKind = "schema removed"
+)
+
+// SchemaChange is a single difference between two schema versions
+type SchemaChange struct {
+	Path     string // Dotted path of the field, elements of arrays are addressed without index (eg. "Devices.Port")
+	Kind     SchemaChangeKind
+	Breaking bool // Existing configs might not load or validate anymore
+	Message  string
+}
+
+func (c SchemaChange) String() string {
+	compatibility := "compatible"
+	if c.Breaking {
+		compatibility = "BREAKING"
+	}
+	return fmt.Sprintf("%s %s: %s (%s)", compatibility, c.Path, c.Kind, c.Message)
+}
+
+// compatibleTypeChanges lists type changes that keep all existing values valid.
+// Passwords can not become other types, their encrypted values would be read as literal text
+var compatibleTypeChanges = map[cs.ValueType][]cs.ValueType{
+	cs.ValueType_String:        {cs.ValueType_IP, cs.ValueType_Password},
+	cs.ValueType_IP:            {cs.ValueType_String, cs.ValueType_Password},
+	cs.ValueType_Select:        {cs.ValueType_String, cs.ValueType_IP, cs.ValueType_Password},
+	cs.ValueType_Integer:       {cs.ValueType_Float},
+	cs.ValueType_Port:          {cs.ValueType_Integer, cs.ValueType_Float},
+	cs.ValueType_IntegerSelect: {cs.ValueType_Integer, cs.ValueType_Float},
+	cs.ValueType_MultiSelect:   {cs.ValueType_Array},
+}
+
+// CompareSchemas compares two versions of a schema and classifies every difference as compatible or breaking for existing configs.
+// A nil schema is a version without schema, adding one is compatible and removing it breaking
+func CompareSchemas(oldSchema, newSchema *cs.ValueTypeDescriptor) []SchemaChange {
+	switch {
+	case oldSchema == nil && newSchema == nil:
+		return nil
+	case oldSchema == nil:
+		return []SchemaChange{{Kind: SchemaChange_SchemaAdded, Message: "there was no schema before"}}
+	case newSchema == nil:
+		return []SchemaChange{{Kind: SchemaChange_SchemaRemoved, Breaking: true, Message: "existing configs can not be validated anymore"}}
+	}
+
+	var changes []SchemaChange
+	compareDescriptors(oldSchema, newSchema, "", &changes)
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// HasBreakingChanges returns true if one of the changes is breaking
+func HasBreakingChanges(changes []SchemaChange) bool {
+	for _, change := range changes {
+		if change.Breaking {
+			return true
+		}
+	}
+	return false
+}
+
+func compareDescriptors(oldVtd, newVtd *cs.ValueTypeDescriptor, path string, changes *[]SchemaChange) {
+	add := func(kind SchemaChangeKind, breaking bool, format string, args ...interface{}) {
+		*changes = append(*changes, SchemaChange{Path: path, Kind: kind, Breaking: breaking, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if oldVtd.Type != newVtd.Type {
+		compatible := false
+		for _, t := range compatibleTypeChanges[oldVtd.Type] {
+			compatible = compatible || t == newVtd.Type
+		}
+		add(SchemaChange_TypeChanged, !compatible, "%s to %s", valueTypeName(oldVtd.Type), valueTypeName(newVtd.Type))
+		if !compatible {
+			return
+		}
+	}
+
+	if newVtd.Type == cs.ValueType_Select || newVtd.Type == cs.ValueType_IntegerSelect || newVtd.Type == cs.ValueType_MultiSelect {
+		for _, option := range oldVtd.Options {
+			if !containsString(newVtd.Options, option) {
+				add(SchemaChange_OptionRemoved, true, "option %q was removed", option)
+			}
+		}
+		for _, option := range newVtd.Options {
+			if !containsString(oldVtd.Options, option) {
+				add(SchemaChange_OptionAdded, false, "option %q was added", option)
+			}
+		}
+	}
+
+	if oldVtd.Required == "" && newVtd.Required != "" {
+		add(SchemaChange_BecameRequired, newVtd.Default == nil, "field is now required")
+	}
+	if !reflect.DeepEqual(oldVtd.Default, newVtd.Default) {
+		add(SchemaChange_DefaultChanged, false, "%v to %v", oldVtd.Default, newVtd.Default)
+	}
+	if !reflect.DeepEqual(oldVtd.DefaultOnModel, newVtd.DefaultOnModel) {
+		add(SchemaChange_DefaultChanged, false, "defaults on model %v to %v", oldVtd.DefaultOnModel, newVtd.DefaultOnModel)
+	}
+	if len(oldVtd.Models) > 0 || len(newVtd.Models) > 0 {
+		oldModels, newModels := modelIDs(oldVtd.Models), modelIDs(newVtd.Models)
+		for _, id := range oldModels {
+			if len(newModels) > 0 && !containsInt(newModels, id) {
+				add(SchemaChange_ModelRemoved, true, "model %d was removed", id)
+			}
+		}
+		for _, id := range newModels {
+			if !containsInt(oldModels, id) {
+				add(SchemaChange_ModelAdded, false, "model %d was added", id)
+			}
+		}
+	}
+	if oldVtd.MinItems != newVtd.MinItems || oldVtd.MaxItems != newVtd.MaxItems {
+		stricter := newVtd.MinItems > oldVtd.MinItems || newVtd.MaxItems > 0 && (oldVtd.MaxItems == 0 || newVtd.MaxItems < oldVtd.MaxItems)
+		add(SchemaChange_ItemLimitsChanged, stricter, "%s to %s", itemLimitsText(oldVtd), itemLimitsText(newVtd))
+	}
+	if !reflect.DeepEqual(oldVtd.DispatchOptions, newVtd.DispatchOptions) {
+		removed := false // Only removed or changed roles break the devices of existing configs
+		for _, role := range oldVtd.DispatchOptions {
+			removed = removed || !containsString(newVtd.DispatchOptions, role)
+		}
+		add(SchemaChange_DispatchChanged, removed, "%v to %v", oldVtd.DispatchOptions, newVtd.DispatchOptions)
+	}
+
+	switch newVtd.Type {
+	case cs.ValueType_Structure, cs.ValueType_StructureArray:
+		compareSubtypes(oldVtd.StructureSubtypes, newVtd.StructureSubtypes, path, changes)
+		if newVtd.Type == cs.ValueType_StructureArray {
+			oldUnique, newUnique := uniqueIncField(oldVtd.StructureSubtypes), uniqueIncField(newVtd.StructureSubtypes)
+			if oldUnique != newUnique {
+				add(SchemaChange_UniqueIncMoved, true, "unique_inc field moved from %q to %q", oldUnique, newUnique)
+			}
+		}
+	case cs.ValueType_Array:
+		if oldVtd.ArraySubType != nil && newVtd.ArraySubType != nil {
+			compareDescriptors(oldVtd.ArraySubType, newVtd.ArraySubType, path, changes)
+		}
+	}
+}
+
+func compareSubtypes(oldSubtypes, newSubtypes map[string]*cs.ValueTypeDescriptor, path string, changes *[]SchemaChange) {
+	for _, name := range sortedNames(oldSubtypes) {
+		oldVtd := oldSubtypes[name]
+		if oldVtd == nil {
+			continue
+		}
+		if newVtd := newSubtypes[name]; newVtd != nil {
+			compareDescriptors(oldVtd, newVtd, joinPath(path, name), changes)
+			continue
+		}
+		*changes = append(*changes, SchemaChange{Path: joinPath(path, name), Kind: SchemaChange_FieldRemoved, Breaking: true, Message: "existing values are not part of the schema anymore"})
+	}
+
+	for _, name := range sortedNames(newSubtypes) {
+		newVtd := newSubtypes[name]
+		if newVtd == nil || oldSubtypes[name] != nil {
+			continue
+		}
+		if newVtd.Required != "" && newVtd.Default == nil {
+			*changes = append(*changes, SchemaChange{Path: joinPath(path, name), Kind: SchemaChange_RequiredFieldAdded, Breaking: true, Message: "new required field without default"})
+			continue
+		}
+		*changes = append(*changes, SchemaChange{Path: joinPath(path, name), Kind: SchemaChange_FieldAdded, Message: "new field"})
+	}
+}
+
+func uniqueIncField(subtypes map[string]*cs.ValueTypeDescriptor) string {
+	for _, name := range sortedNames(subtypes) {
+		if vtd := subtypes[name]; vtd != nil && vtd.Type == cs.ValueType_UniqueInc {
+			return name
+		}
+	}
+	return ""
+}
+
+func sortedNames(subtypes map[string]*cs.ValueTypeDescriptor) []string {
+	names := make([]string, 0, len(subtypes))
+	for name := range subtypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func itemLimitsText(vtd *cs.ValueTypeDescriptor) string {
+	max := "unlimited"
+	if vtd.MaxItems > 0 {
+		max = strconv.Itoa(vtd.MaxItems)
+	}
+	return fmt.Sprintf("%d..%s items", vtd.MinItems, max)
+}
+
+func modelIDs(models []cs.Model) []int {
+	ids := make([]int, len(models))
+	for i, model := range models {
+		ids[i] = model.ID
+	}
+	return ids
+}
+
+var valueTypeNames = map[cs.ValueType]string{
+	cs.ValueType_Unknown:        "unknown",
+	cs.ValueType_Integer:        "integer",
+	cs.ValueType_Float:          "float",
+	cs.ValueType_String:         "string",
+	cs.ValueType_Port:           "port",
+	cs.ValueType_IP:             "ip",
+	cs.ValueType_Checkbox:       "checkbox",
+	cs.ValueType_Structure:      "structure",
+	cs.ValueType_Array:          "array",
+	cs.ValueType_StructureArray: "structure array",
+	cs.ValueType_Password:       "password",
+	cs.ValueType_Select:         "select",
+	cs.ValueType_UniqueInc:      "unique_inc",
+	cs.ValueType_IntegerSelect:  "integer select",
+	cs.ValueType_MultiSelect:    "multi select",
+}
+
+func valueTypeName(t cs.ValueType) string {
+	if name, ok := valueTypeNames[t]; ok {
+		return name
+	}
+	return strconv.Itoa(t)
+}
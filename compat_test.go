@@ -0,0 +1,89 @@
+package config_test
+
+import (
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+)
+
+func TestCompareSchemas(t *testing.T) {
+	type OldDevice struct {
+		conf.BaseDeviceConfig
+		IP       string
+		Mode     string `ibOptions:"Auto,Manual,Legacy"`
+		Obsolete bool
+		Secret   string `ibValidate:"password"`
+		Port     int
+		Host     string `ibDispatch:"deviceip"`
+	}
+	type OldConfig struct {
+		PollMs  int
+		Devices []OldDevice
+	}
+
+	type NewDevice struct {
+		conf.BaseDeviceConfig
+		IP       string `ibValidate:"ip"`
+		Mode     string `ibOptions:"Auto,Manual,Remote"`
+		Token    string `ibRequired:"Please enter the API token"`
+		Timeout  int    `ibRequired:"Please enter a timeout" ibDefault:"10"`
+		Presence bool
+		Secret   string
+		Port     int `ibDispatch:"port"`
+		Host     string
+	}
+	type NewConfig struct {
+		PollMs  float64
+		Devices []NewDevice
+	}
+
+	changes := conf.CompareSchemas(conf.GetSchema(&OldConfig{}), conf.GetSchema(&NewConfig{}))
+
+	expected := map[string]bool{
+		"Devices.IP type changed":            false,
+		"Devices.Mode option removed":        true,
+		"Devices.Mode option added":          false,
+		"Devices.Obsolete field removed":     true,
+		"Devices.Presence field added":       false,
+		"Devices.Timeout field added":        false,
+		"Devices.Token required field added": true,
+		"Devices.Secret type changed":        true,  // Encrypted values would become literal
+		"Devices.Port dispatch changed":      false, // Added role
+		"Devices.Host dispatch changed":      true,
+		"PollMs type changed":                false,
+	}
+	if len(changes) != len(expected) {
+		t.Errorf("expected %d changes, got %d: %v", len(expected), len(changes), changes)
+	}
+	for _, change := range changes {
+		breaking, ok := expected[change.Path+" "+change.Kind]
+		if !ok {
+			t.Errorf("unexpected change %s", change)
+			continue
+		}
+		if breaking != change.Breaking {
+			t.Errorf("expected breaking=%v for %s", breaking, change)
+		}
+	}
+	if !conf.HasBreakingChanges(changes) {
+		t.Errorf("expected breaking changes")
+	}
+	if changes := conf.CompareSchemas(conf.GetSchema(&NewConfig{}), conf.GetSchema(&NewConfig{})); len(changes) != 0 {
+		t.Errorf("expected no changes for identical schemas, got %v", changes)
+	}
+
+	// Values of fields that changed from int to float still validate
+	if _, err := conf.ValidateConfig(conf.GetSchema(&NewConfig{}), map[string]interface{}{"PollMs": int64(100)}, false, "test"); err != nil {
+		t.Errorf("expected integer value to be valid for float field, got %v", err)
+	}
+
+	if changes := conf.CompareSchemas(nil, conf.GetSchema(&NewConfig{})); len(changes) != 1 || changes[0].Kind != conf.SchemaChange_SchemaAdded || changes[0].Breaking {
+		t.Errorf("expected compatible schema added change, got %v", changes)
+	}
+	if changes := conf.CompareSchemas(conf.GetSchema(&OldConfig{}), nil); len(changes) != 1 || changes[0].Kind != conf.SchemaChange_SchemaRemoved || !changes[0].Breaking {
+		t.Errorf("expected breaking schema removed change, got %v", changes)
+	}
+	if changes := conf.CompareSchemas(nil, nil); len(changes) != 0 {
+		t.Errorf("expected no changes without schemas, got %v", changes)
+	}
+}
@@ -75,11 +75,23 @@ func storeSchema(file string, structure interface{}) error {
 }
 
 func generateSchema(v reflect.Type) *cs.ValueTypeDescriptor { // If fail: fatal
-	return getTypeDescriptor(v, "", nil)
+	vtd := getTypeDescriptor(v, "", nil)
+	if errs := ValidateDispatch(vtd); len(errs) > 0 {
+		problems := make([]string, len(errs))
+		for i, err := range errs {
+			problems[i] = err.Error()
+		}
+		log.Fatalf("Invalid dispatch tags in config structure:\n%s", strings.Join(problems, "\n"))
+	}
+	applyModels(vtd)
+	applyPresets(vtd)
+	applyItemLimits(vtd)
+	applyOptionsProviders(vtd)
+	return vtd
 }
 
 func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *reflect.StructTag) *cs.ValueTypeDescriptor {
-	var validateTag, descriptionTag, optionsTag, dispatchTag, hiddenTag, orderTag, defaultTag, labelTag, requiredTag, onlyOnModelTag, notOnModelTag, headline string
+	var validateTag, descriptionTag, optionsTag, dispatchTag, hiddenTag, orderTag, defaultTag, labelTag, requiredTag, onlyOnModelTag, notOnModelTag, headline, secretTag, defaultOnModelTag, minItemsTag, maxItemsTag string
 	if parentTag != nil {
 		if parentTag.Get("json") == "-" {
 			return nil
@@ -96,9 +108,13 @@ func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *refle
 		onlyOnModelTag = parentTag.Get("ibOnlyOnModel")
 		notOnModelTag = parentTag.Get("ibNotOnModel")
 		defaultTag = parentTag.Get("ibDefault")
+		defaultOnModelTag = parentTag.Get("ibDefaultOnModel")
 		labelTag = parentTag.Get("ibLabel")
 		requiredTag = parentTag.Get("ibRequired")
 		hiddenTag = parentTag.Get("ibHidden")
+		secretTag = parentTag.Get("ibSecret")
+		minItemsTag = parentTag.Get("ibMinItems")
+		maxItemsTag = parentTag.Get("ibMaxItems")
 	}
 
 	vtd := new(cs.ValueTypeDescriptor)
@@ -107,14 +123,16 @@ func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *refle
 	vtd.Hidden = hiddenTag
 	vtd.Label = labelTag
 
+	if secretTag != "" {
+		secret, err := strconv.ParseBool(secretTag)
+		log.MustFatal(log.Wrap(err, "failed to validate config tag for secret: (%s)", secretTag))
+		vtd.Secret = secret
+	}
+
 	if onlyOnModelTag != "" {
-		all := strings.Split(onlyOnModelTag, ",")
-		vtd.OnlyOnModel = make([]int, len(all))
-		for i, m := range all {
-			num, err := strconv.ParseInt(m, 10, 32)
-			log.MustFatal(log.Wrap(err, "failed to validate config tag for onlyOnModel: (%s)", onlyOnModelTag))
-			vtd.OnlyOnModel[i] = int(num)
-		}
+		models, err := parseModelTag(onlyOnModelTag)
+		log.MustFatal(log.Wrap(err, "failed to validate config tag for onlyOnModel: (%s)", onlyOnModelTag))
+		vtd.OnlyOnModel = models
 	}
 
 	if headline != "" {
@@ -122,13 +140,9 @@ func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *refle
 	}
 
 	if notOnModelTag != "" {
-		all := strings.Split(notOnModelTag, ",")
-		vtd.NotOnModel = make([]int, len(all))
-		for i, m := range all {
-			num, err := strconv.ParseInt(m, 10, 32)
-			log.MustFatal(log.Wrap(err, "failed to validate config tag for notOnModel: (%s)", notOnModelTag))
-			vtd.NotOnModel[i] = int(num)
-		}
+		models, err := parseModelTag(notOnModelTag)
+		log.MustFatal(log.Wrap(err, "failed to validate config tag for notOnModel: (%s)", notOnModelTag))
+		vtd.NotOnModel = models
 	}
 
 	if dispatchTag != "" {
@@ -157,9 +171,6 @@ func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *refle
 		}
 
 		if sliceType.Kind() == reflect.Struct {
-			//if dispatchTag != "" && dispatchTag != "devices" {
-			//	log.Fatal("can not use dispatch tag other than devices currently")
-			//}
 			if dispatchTag == "devices" || strings.ToLower(fieldName) == "devices" {
 				var dcIface ibeamDeviceConfig
 				if !sliceType.Implements(reflect.TypeOf(&dcIface).Elem()) {
@@ -190,23 +201,36 @@ func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *refle
 				}
 				vtd.StructureSubtypes[sliceType.Field(i).Name] = getTypeDescriptor(sliceType.Field(i).Type, sliceType.Field(i).Name, &tag)
 			}
+		} else if optionsTag != "" && sliceType.Kind() == reflect.String {
+			vtd.Type = cs.ValueType_MultiSelect
+			if defaultTag != "" {
+				vtd.Default = strings.Split(defaultTag, ",")
+			}
 		} else {
-			//if dispatchTag != "" {
-			//	log.Fatal("can not use dispatch tag on other fields than structured array")
-			//}
 			vtd.Type = cs.ValueType_Array
 			vtd.ArraySubType = getTypeDescriptor(sliceType, fieldName, parentTag)
 		}
+
+		if minItemsTag != "" {
+			minItems, err := strconv.Atoi(minItemsTag)
+			log.MustFatal(log.Wrap(err, "failed to validate config tag for minItems on %s: (%s)", fieldName, minItemsTag))
+			vtd.MinItems = minItems
+		}
+		if maxItemsTag != "" {
+			maxItems, err := strconv.Atoi(maxItemsTag)
+			log.MustFatal(log.Wrap(err, "failed to validate config tag for maxItems on %s: (%s)", fieldName, maxItemsTag))
+			vtd.MaxItems = maxItems
+		}
+		log.MustFatal(log.Wrap(validateItemLimits(vtd.MinItems, vtd.MaxItems), "failed to validate item limits on %s", fieldName))
 		return vtd
 	} else if typeName.Kind() == reflect.Struct {
-		//if dispatchTag != "" {
-		//	log.Fatal("can not use dispatch tag on other fields than structured array")
-		//}
+		secret := vtd.Secret
 		vtd = structTypeDescriptor(typeName)
 		vtd.Description = descriptionTag
 		vtd.Required = requiredTag
 		vtd.Hidden = hiddenTag
 		vtd.Label = labelTag
+		vtd.Secret = secret
 		return vtd
 	}
 
@@ -214,6 +238,21 @@ func getTypeDescriptor(typeName reflect.Type, fieldName string, parentTag *refle
 		vtd.Options = strings.Split(optionsTag, ",")
 	}
 	vtd.Type, vtd.Default = getType(typeName.Name(), fieldName, validateTag, optionsTag, dispatchTag, defaultTag)
+
+	if defaultOnModelTag != "" {
+		modelDefaults, err := parseDefaultOnModel(defaultOnModelTag)
+		log.MustFatal(log.Wrap(err, "failed to validate config tag for defaultOnModel on %s: (%s)", fieldName, defaultOnModelTag))
+		vtd.DefaultOnModel = make(map[int]interface{}, len(modelDefaults))
+		for model, value := range modelDefaults {
+			parsed := reflect.New(typeName).Elem()
+			log.MustFatal(log.Wrap(setFromString(parsed, value), "failed to validate config tag for defaultOnModel on %s: (%s)", fieldName, defaultOnModelTag))
+			_, def := getType(typeName.Name(), fieldName, validateTag, optionsTag, dispatchTag, value)
+			if def == nil { // getType has no default for false
+				def = parsed.Interface()
+			}
+			vtd.DefaultOnModel[model] = def
+		}
+	}
 	return vtd
 }
 
@@ -231,6 +270,26 @@ func structTypeDescriptor(field reflect.Type) *cs.ValueTypeDescriptor {
 	return vtd
 }
 
+// parseDefaultOnModel parses an ibDefaultOnModel tag like "3=9910,7=52381" into the defaults by model id
+func parseDefaultOnModel(tag string) (map[int]string, error) {
+	defaults := make(map[int]string)
+	for _, entry := range strings.Split(tag, ",") {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%q is no model=value pair", entry)
+		}
+		model, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%q is no model id", parts[0])
+		}
+		if _, exists := defaults[model]; exists {
+			return nil, fmt.Errorf("model %d has several defaults", model)
+		}
+		defaults[model] = parts[1]
+	}
+	return defaults, nil
+}
+
 func getType(typeName, fieldName, validateTag, optionsTag, dispatchTag, defaultTag string) (vt cs.ValueType, defValue interface{}) {
 	switch typeName {
 	case "string":
@@ -257,6 +316,16 @@ func getType(typeName, fieldName, validateTag, optionsTag, dispatchTag, defaultT
 		if defaultTag != "" {
 			defValue, _ = strconv.Atoi(defaultTag)
 		}
+
+		if optionsTag != "" {
+			for _, option := range strings.Split(optionsTag, ",") {
+				if _, err := strconv.Atoi(option); err != nil {
+					log.Fatalf("Invalid integer option '%s' on %s", option, fieldName)
+				}
+			}
+			return cs.ValueType_IntegerSelect, defValue
+		}
+
 		switch validateTag {
 		case "":
 			return cs.ValueType_Integer, defValue
@@ -285,10 +354,18 @@ func getType(typeName, fieldName, validateTag, optionsTag, dispatchTag, defaultT
 }
 
 // Load a package config, also storing the default config and schema for ibeam-init to pick up
+// Fields missing in the config file are filled with the values of the passed in default structure
 func Load(structure interface{}) error {
+	_, err := LoadWithReport(structure)
+	return err
+}
+
+// LoadWithReport loads a package config like Load and reports which keys have been filled with defaults
+func LoadWithReport(structure interface{}) (*LoadReport, error) {
 	if coreName == "" {
 		log.Panic("no corename set")
 	}
+	RefreshOptions()
 	// then it checks if the config exists, if not store default config
 	// Then load config
 
@@ -300,77 +377,188 @@ func Load(structure interface{}) error {
 		}
 	}
 
-	baseFileName := filepath.Join(path, coreName, coreName)
-	if devMode {
-		baseFileName = filepath.Join(path, coreName)
-	}
+	baseFileName := getBaseFileName(coreName)
 
-	data, err := os.ReadFile(baseFileName + ".toml")
+	detected, detectedExists := detectConfigFormat()
+	format, err := activeConfigFormat()
 	if err != nil {
-		// There is a chance that file we are looking for
-		// just doesn't exist. In this case we are supposed
-		// to create an empty configuration file, based on v.
-		if saveErr := Save(structure); saveErr != nil {
-			return saveErr
-		}
-		data, err = os.ReadFile(baseFileName + ".toml")
+		return nil, err
+	}
+	converted := detectedExists && detected != format
+
+	// There is a chance that file we are looking for just doesn't exist.
+	// In this case the config is loaded from the other layers and the defaults, and the file is created afterwards
+	data, err := os.ReadFile(configFileName(coreName, format))
+	userFileExists := err == nil
+	var integrity IntegrityStatus
+	if userFileExists {
+		data, integrity, err = checkIntegrity(configFileName(coreName, format), data)
 		if err != nil {
-			return log.Wrap(err, "on reading after saving")
-
+			return nil, err
 		}
 	}
 
 	err = storeSchema(baseFileName+".schema.json", structure)
 	if err != nil {
-		return fmt.Errorf("on storing schema: %w", err)
+		return nil, fmt.Errorf("on storing schema: %w", err)
 	}
 
-	err = save(structure, coreName+".default")
+	err = saveAnnotated(structure, coreName+".default")
 	if err != nil {
-		return fmt.Errorf("on storing : %w", err)
+		return nil, fmt.Errorf("on storing : %w", err)
 	}
 
 	p := reflect.ValueOf(structure).Elem()
+	data, err = mergeLayers(p.Type(), data, format)
+	if err != nil {
+		return nil, err
+	}
+	schema := GetSchema(structure)
+	data, err = decryptPasswords(data, schema)
+	if err != nil {
+		return nil, err
+	}
+	data, err = resolveSecretReferences(data, schema)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults := reflect.New(p.Type()).Elem()
+	defaults.Set(p)
 	p.Set(reflect.Zero(p.Type()))
 
-	err = toml.Unmarshal(data, structure)
+	md, err := toml.Decode(string(data), structure)
 	if err != nil {
-		return fmt.Errorf("on decoding toml: %w", err)
+		return nil, fmt.Errorf("on decoding toml: %w", err)
 	}
 
-	return nil
+	raw := make(map[string]interface{})
+	err = toml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("on decoding toml: %w", err)
+	}
+
+	report := &LoadReport{Integrity: integrity}
+	fillDefaults(p, defaults, raw, "", userFileExists, report)
+	if len(report.FilledKeys) > 0 {
+		log.Debugf("filled keys missing in config with defaults: %s", strings.Join(report.FilledKeys, ", "))
+	}
+
+	report.UnknownKeys = collectUnknownKeys(md, raw)
+	if len(report.UnknownKeys) > 0 {
+		if strictLoad {
+			return report, unknownKeysError(report.UnknownKeys)
+		}
+		log.Warnf("ignoring unknown keys in config: %s", strings.Join(report.UnknownKeys, ", "))
+	}
+
+	if err := storeLoadedValues(structure); err != nil {
+		return nil, err
+	}
+	recordFilled(report.FilledKeys)
+	if converted {
+		recordConverted(configFileName(coreName, format))
+	}
+
+	if !userFileExists {
+		if annotateInitialConfig && format == ConfigFormat_TOML {
+			if data, err = encodeUserLayer(structure); err == nil {
+				err = writeUserFile(configFileName(coreName, format), annotateTOML(data, GetSchema(structure)))
+			}
+		} else {
+			err = saveUserFile(structure) // With the options read by this Load
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return report, nil
 }
 
-// Save saves struct to toml
+// Save saves struct to the config file, in the format of the existing file or the one set with SetConfigFormat
 func Save(structure interface{}) error {
 	if coreName == "" {
 		log.Panic("no corename set")
 	}
-	return save(structure, coreName)
+	RefreshOptions()
+	return saveUserFile(structure)
+}
+
+// saveUserFile writes the user layer of a structure to the config file, keeping comments and formatting of existing toml files
+func saveUserFile(structure interface{}) error {
+	data, err := encodeUserLayer(structure)
+	if err != nil {
+		return err
+	}
+
+	orphanedData, err := encodeOrphaned()
+	if err != nil {
+		return err
+	}
+
+	data = append(data, orphanedData...)
+
+	format, err := activeConfigFormat()
+	if err != nil {
+		return err
+	}
+	if format != ConfigFormat_TOML {
+		data, err = fromTOML(format, data)
+		if err != nil {
+			return err
+		}
+		return writeUserFile(configFileName(coreName, format), data)
+	}
+
+	// Only patch the changed values, to keep comments and formatting of hand edited files
+	if existing, err := os.ReadFile(configFileName(coreName, format)); err == nil {
+		if patched, ok := patchTOML(existing, data); ok {
+			data = patched
+		} else {
+			log.Debug("config changed shape, rewriting the whole file")
+		}
+	}
+
+	return writeUserFile(configFileName(coreName, format), data)
 }
 
 // save saves struct to toml
 func save(structure interface{}, filename string) error {
+	data, err := encodeConfig(structure)
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(configFileName(filename, ConfigFormat_TOML), data)
+}
+
+func encodeConfig(structure interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	enc := toml.NewEncoder(&buf)
 	err := enc.Encode(structure)
 	if err != nil {
-		return fmt.Errorf("on encoding toml: %w", err)
-	}
-
-	baseFileName := filepath.Join(path, coreName, filename)
-	if devMode {
-		baseFileName = filepath.Join(path, filename)
+		return nil, fmt.Errorf("on encoding toml: %w", err)
 	}
+	return buf.Bytes(), nil
+}
 
-	err = os.WriteFile(baseFileName+".toml", buf.Bytes(), os.ModePerm)
+func writeConfigFile(file string, data []byte) error {
+	err := os.WriteFile(file, data, os.ModePerm)
 	if err != nil {
-		return fmt.Errorf("on encoding toml: %w", err)
+		return fmt.Errorf("on writing config: %w", err)
 	}
 
 	return nil
 }
 
+// getBaseFileName returns the path of a config file without extension
+func getBaseFileName(filename string) string {
+	if devMode {
+		return filepath.Join(path, filename)
+	}
+	return filepath.Join(path, coreName, filename)
+}
+
 // SetDevMode activates the development mode path configuration
 func SetDevMode(devmode bool) {
 	devMode = devmode
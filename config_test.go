@@ -3,8 +3,12 @@ package config_test
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/BurntSushi/toml"
 	conf "github.com/SKAARHOJ/ibeam-lib-config"
 )
 
@@ -124,3 +128,938 @@ func TestLoad(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestLoadFillsDefaults(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP   string
+		Port uint16 `ibDefault:"9910"`
+		Mode string `ibOptions:"Auto,Manual" ibDefault:"Auto"`
+	}
+
+	type GlobalConfig struct {
+		PollMs  int
+		Verbose bool
+	}
+
+	type Config struct {
+		Global  GlobalConfig
+		Retries int
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-merge")
+	err := os.WriteFile(coreName+".toml", []byte(`
+[Global]
+Verbose = true
+
+[[Devices]]
+DeviceID = 1
+IP = "10.0.0.1"
+
+[[Devices]]
+DeviceID = 2
+IP = "10.0.0.2"
+Port = 52381
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{
+		Global:  GlobalConfig{PollMs: 250},
+		Retries: 3,
+		Devices: []DeviceConfig{{Port: 20}},
+	}
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	report, err := conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Global.PollMs != 250 || !config.Global.Verbose || config.Retries != 3 {
+		t.Errorf("expected defaults to be filled, got %+v", config)
+	}
+	if len(config.Devices) != 2 || config.Devices[0].Port != 9910 || config.Devices[0].Mode != "Auto" || config.Devices[1].Port != 52381 {
+		t.Errorf("expected device defaults to be filled, got %+v", config.Devices)
+	}
+
+	expected := []string{"Global.PollMs", "Retries", "Devices.0.Port", "Devices.0.Mode", "Devices.1.Mode"}
+	if strings.Join(report.FilledKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected filled keys %v, got %v", expected, report.FilledKeys)
+	}
+}
+
+func TestLoadKeepsDeletedDevices(t *testing.T) {
+	type Config struct {
+		PollMs  int
+		Devices []conf.BaseDeviceConfig
+	}
+	defaults := func() Config {
+		return Config{PollMs: 100, Devices: []conf.BaseDeviceConfig{{DeviceID: 1}, {DeviceID: 2}}}
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(filepath.Join(t.TempDir(), "core-deleted"))
+	config := defaults()
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Devices) != 2 {
+		t.Fatalf("expected default devices in new config, got %+v", config.Devices)
+	}
+
+	config.Devices = nil // Left out by the encoder
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	config = defaults()
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Devices) != 0 {
+		t.Errorf("expected deleted devices to stay deleted, got %+v", config.Devices)
+	}
+}
+
+func TestLoadFillsModelDefaults(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		Port  uint16 `ibDefault:"80" ibDefaultOnModel:"3=9910,7=52381"`
+		Tally bool   `ibDefault:"true" ibDefaultOnModel:"7=false"`
+	}
+	type Config struct {
+		Devices []DeviceConfig
+	}
+
+	schema := conf.GetSchema(&Config{})
+	port := schema.StructureSubtypes["Devices"].StructureSubtypes["Port"]
+	if port.DefaultOnModel[3] != 9910 || port.DefaultOnModel[7] != 52381 || port.Default != 80 {
+		t.Errorf("expected defaults on model in schema, got %v", port.DefaultOnModel)
+	}
+	if tally := schema.StructureSubtypes["Devices"].StructureSubtypes["Tally"]; tally.DefaultOnModel[7] != false {
+		t.Errorf("expected false default on model in schema, got %v", tally.DefaultOnModel)
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-models")
+	err := os.WriteFile(coreName+".toml", []byte(`
+[[Devices]]
+DeviceID = 1
+ModelID = 3
+
+[[Devices]]
+DeviceID = 2
+ModelID = 7
+
+[[Devices]]
+DeviceID = 3
+ModelID = 5
+
+[[Devices]]
+DeviceID = 4
+ModelID = 7
+Port = 1234
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := Config{}
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	ports := []uint16{9910, 52381, 80, 1234}
+	tallies := []bool{true, false, true, false}
+	for i, device := range config.Devices {
+		if device.Port != ports[i] || device.Tally != tallies[i] {
+			t.Errorf("expected device %d to get port %d and tally %v, got %+v", i, ports[i], tallies[i], device)
+		}
+	}
+}
+
+func TestLoadUnknownKeys(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IPAddress string
+	}
+
+	type Config struct {
+		Retries int
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-unknown")
+	err := os.WriteFile(coreName+".toml", []byte(`
+Retries = 3
+Retry = 5
+
+[Legacy]
+Mode = "old"
+
+[[Devices]]
+DeviceID = 1
+IpAdress = "10.0.0.1"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetPreserveOrphaned(true)
+	defer conf.SetPreserveOrphaned(false)
+
+	var config Config
+	report, err := conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"Devices.0.IpAdress", "Legacy", "Retry"}
+	if strings.Join(report.UnknownKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected unknown keys %v, got %v", expected, report.UnknownKeys)
+	}
+
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	config = Config{}
+	report, err = conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.UnknownKeys) != 0 || config.Retries != 3 {
+		t.Errorf("expected preserved keys not to be reported again, got %v", report.UnknownKeys)
+	}
+	if orphaned := conf.GetOrphaned(); orphaned["Devices.0.IpAdress"] != "10.0.0.1" || orphaned["Retry"] != int64(5) {
+		t.Errorf("expected orphaned keys to be preserved, got %v", orphaned)
+	}
+
+	err = os.WriteFile(coreName+".toml", []byte("Retry = 5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.SetStrictLoad(true)
+	defer conf.SetStrictLoad(false)
+	if err := conf.Load(&config); err == nil {
+		t.Errorf("expected strict load to fail on unknown keys")
+	}
+}
+
+func TestOrphanedKeysOfOtherLayers(t *testing.T) {
+	type Config struct {
+		PollMs int
+	}
+
+	dir := t.TempDir()
+	coreName := filepath.Join(dir, "core-orphaned-layers")
+	files := map[string]string{
+		"vendor.toml":                         "PollMs = 200\nVendorKey = 1\n",
+		"core-orphaned-layers.toml":           "PollMs = 100\nUserKey = 2\n",
+		"core-orphaned-layers.d/10-site.toml": "SiteKey = 3\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetVendorFile(filepath.Join(dir, "vendor.toml"))
+	conf.SetPreserveOrphaned(true)
+	defer func() {
+		conf.SetVendorFile("")
+		conf.SetPreserveOrphaned(false)
+	}()
+
+	var config Config
+	report, err := conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"SiteKey", "UserKey", "VendorKey"}; strings.Join(report.UnknownKeys, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected unknown keys of all layers %v, got %v", expected, report.UnknownKeys)
+	}
+	if orphaned := conf.GetOrphaned(); len(orphaned) != 1 || orphaned["UserKey"] != int64(2) {
+		t.Errorf("expected only unknown keys of the user file to be orphaned, got %v", orphaned)
+	}
+
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(coreName + ".toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(saved), "UserKey") || strings.Contains(string(saved), "VendorKey") || strings.Contains(string(saved), "SiteKey") {
+		t.Errorf("expected only the unknown key of the user file to be kept, got:\n%s", saved)
+	}
+}
+
+func TestSavePreservesComments(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP   string
+		Tags []string
+	}
+
+	type Config struct {
+		PollMs  int
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-comments")
+	original := `# Tuned for the studio network
+PollMs    = 250 # do not go below 100
+
+# Main switcher
+[[Devices]]
+DeviceID = 1
+IP = "10.0.0.1" # fixed lease
+Tags = [
+  "main",  # the big one
+  "tally",
+]
+`
+	if err := os.WriteFile(coreName+".toml", []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	var config Config
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	config.PollMs = 500
+	config.Devices[0].IP = "10.0.0.2"
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(coreName + ".toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keys missing in the file are added to the end of their table
+	expected := strings.Replace(strings.Replace(original, "250", "500", 1), "10.0.0.1", "10.0.0.2", 1) + "Active = false\nName = \"\"\nModelID = 0\nDescription = \"\"\n"
+	if string(data) != expected {
+		t.Errorf("expected only values to change, got:\n%s", data)
+	}
+
+	config.Devices = append(config.Devices, DeviceConfig{IP: "10.0.0.3"})
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	config = Config{}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Devices) != 2 || config.PollMs != 500 {
+		t.Errorf("expected rewritten config after change of shape, got %+v", config)
+	}
+}
+
+func TestAnnotatedDefaultConfig(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP   string `ibRequired:"Please enter the IP of the device"`
+		Mode string `ibOptions:"Auto,Manual" ibDescription:"How the device is controlled"`
+	}
+
+	type Config struct {
+		PollMs  int `ibLabel:"Poll Interval"`
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-annotated")
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetAnnotateInitialConfig(true)
+	defer conf.SetAnnotateInitialConfig(false)
+
+	config := Config{PollMs: 100, Devices: []DeviceConfig{{IP: "10.0.0.1"}}}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, filename := range []string{coreName + ".default.toml", coreName + ".toml"} {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, comment := range []string{"# Poll Interval\n", "# How the device is controlled\n", "# Options: Auto, Manual\n", "# Required: Please enter the IP of the device\n"} {
+			if !strings.Contains(string(data), comment) {
+				t.Errorf("expected %q in %s, got:\n%s", comment, filename, data)
+			}
+		}
+	}
+
+	loaded := Config{}
+	if err := conf.Load(&loaded); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.PollMs != 100 || len(loaded.Devices) != 1 || loaded.Devices[0].IP != "10.0.0.1" {
+		t.Errorf("expected annotated config to load, got %+v", loaded)
+	}
+}
+
+func TestConfigFormats(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP   string
+		Port uint16 `ibDefault:"9910"`
+	}
+
+	type Config struct {
+		PollMs  int
+		Ratio   float64
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-formats")
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	defer conf.SetConfigFormat("")
+
+	yamlConfig := `PollMs: 250
+Ratio: 1.5
+Devices:
+  - DeviceID: 1
+    IP: 10.0.0.1
+`
+	if err := os.WriteFile(coreName+".yaml", []byte(yamlConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var config Config
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.PollMs != 250 || config.Ratio != 1.5 || len(config.Devices) != 1 || config.Devices[0].IP != "10.0.0.1" || config.Devices[0].Port != 9910 {
+		t.Fatalf("expected config loaded from detected yaml file, got %+v", config)
+	}
+	if _, err := os.Stat(coreName + ".toml"); err == nil {
+		t.Errorf("expected no toml config next to the yaml config")
+	}
+
+	conf.SetConfigFormat(conf.ConfigFormat_JSON)
+	config = Config{}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(coreName + ".yaml.bak"); err != nil {
+		t.Errorf("expected yaml config to be kept as backup after conversion: %v", err)
+	}
+
+	config.Devices[0].Port = 52381
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(coreName + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"Port": 52381`) {
+		t.Errorf("expected saved value in json config, got:\n%s", data)
+	}
+
+	config = Config{}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.PollMs != 250 || config.Devices[0].Port != 52381 || config.Devices[0].DeviceID != 1 {
+		t.Errorf("expected config loaded from json file, got %+v", config)
+	}
+
+	if err := conf.ConvertConfig(conf.ConfigFormat_TOML); err != nil {
+		t.Fatal(err)
+	}
+	conf.SetConfigFormat(conf.ConfigFormat_TOML)
+	config = Config{}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Ratio != 1.5 || config.Devices[0].Port != 52381 {
+		t.Errorf("expected config converted to toml, got %+v", config)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP   string
+		Port uint16
+	}
+
+	type Config struct {
+		PollMs  int
+		Retries int
+		Timeout int
+		Devices []DeviceConfig
+	}
+
+	dir := t.TempDir()
+	coreName := filepath.Join(dir, "core-layers")
+	files := map[string]string{
+		"vendor.json": `{"PollMs": 200, "Devices": [{"DeviceID": 1, "IP": "10.0.0.1", "Port": 9910}]}`,
+		"core-layers.toml": `Retries = 5
+
+[[Devices]]
+DeviceID = 1
+Port = 52381
+
+[[Devices]]
+DeviceID = 2
+IP = "10.0.0.2"
+`,
+		"core-layers.d/10-site.toml": "Retries = 7\n",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetVendorFile(filepath.Join(dir, "vendor.json"))
+	conf.SetEnvPrefix("CORE_LAYERS")
+	t.Setenv("CORE_LAYERS_DEVICES_1_IP", "10.0.0.9")
+	conf.SetFlagOverride("PollMs", "300")
+	defer func() {
+		conf.SetVendorFile("")
+		conf.SetEnvPrefix("")
+		conf.ClearFlagOverrides()
+	}()
+
+	config := Config{PollMs: 100, Retries: 3, Timeout: 10}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.PollMs != 300 || config.Retries != 7 || config.Timeout != 10 || len(config.Devices) != 2 {
+		t.Fatalf("expected merged config, got %+v", config)
+	}
+	if config.Devices[0].IP != "10.0.0.1" || config.Devices[0].Port != 52381 || config.Devices[1].IP != "10.0.0.9" {
+		t.Errorf("expected devices merged by DeviceID, got %+v", config.Devices)
+	}
+
+	layers := map[string]conf.Layer{
+		"PollMs":         conf.Layer_Flag,
+		"Retries":        conf.Layer_DropIn,
+		"Timeout":        conf.Layer_Default,
+		"Devices.0.IP":   conf.Layer_Vendor,
+		"Devices.0.Port": conf.Layer_User,
+		"Devices.1.IP":   conf.Layer_Env,
+	}
+	for path, layer := range layers {
+		if got := conf.GetLayer(path); got != layer {
+			t.Errorf("expected %s from layer %s, got %s", path, layer, got)
+		}
+	}
+
+	config.Devices[0].Port = 1
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	user := make(map[string]interface{})
+	if _, err := toml.DecodeFile(coreName+".toml", &user); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := user["PollMs"]; ok || user["Retries"] != int64(5) {
+		t.Errorf("expected only the user layer to be saved, got %v", user)
+	}
+	devices := user["Devices"].([]map[string]interface{})
+	if len(devices) != 2 || devices[0]["Port"] != int64(1) || devices[0]["IP"] != nil || devices[1]["IP"] != "10.0.0.2" {
+		t.Errorf("expected only changed device values to be saved, got %v", devices)
+	}
+
+	config = Config{PollMs: 100, Retries: 3, Timeout: 10}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.PollMs != 300 || config.Devices[0].IP != "10.0.0.1" || config.Devices[0].Port != 1 || config.Devices[1].IP != "10.0.0.9" {
+		t.Errorf("expected saved config to merge with the other layers again, got %+v", config)
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP       string
+		Port     int    `ibDefault:"9910"`
+		Password string `ibValidate:"password"`
+	}
+
+	type Config struct {
+		PollMs  int
+		Retries int
+		Timeout int
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-provenance")
+	err := os.WriteFile(coreName+".json", []byte(`{
+  "PollMs": 250,
+  "Devices": [
+    {
+      "DeviceID": 1,
+      "IP": "10.0.0.1",
+      "Password": "secret"
+    }
+  ]
+}
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetFlagOverride("Retries", "5")
+	defer conf.ClearFlagOverrides()
+
+	config := Config{Retries: 3, Timeout: 10}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	conf.SetProvenance("Devices.0.Name", conf.Source_Migrated)
+
+	checkProvenance := func(expected map[string]string) {
+		t.Helper()
+		for _, p := range conf.GetProvenance() {
+			if want, ok := expected[p.Path]; ok && fmt.Sprintf("%s:%d", p.Source, p.Line) != want {
+				t.Errorf("expected %s from %s, got %s:%d", p.Path, want, p.Source, p.Line)
+			}
+		}
+	}
+	checkProvenance(map[string]string{
+		"PollMs":             "file:2",
+		"Retries":            "flag:0",
+		"Timeout":            "auto-assigned:0", // Filled in from the structure passed to Load
+		"Devices.0.IP":       "file:6",
+		"Devices.0.Port":     "auto-assigned:0", // Filled in from the ibDefault tag
+		"Devices.0.Password": "file:7",
+		"Devices.0.Name":     "migrated:0",
+		"Devices.0.ModelID":  "default:0",
+	})
+
+	var buf strings.Builder
+	if err := conf.WriteProvenance(&buf, conf.GetSchema(&config)); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") || !strings.Contains(buf.String(), "********") {
+		t.Errorf("expected password to be masked, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "core-provenance.json:6") {
+		t.Errorf("expected file and line of values, got:\n%s", buf.String())
+	}
+
+	// Plain text passwords encrypted on Save and files converted to another format are migrated
+	conf.SetKeyProvider(conf.StaticKey([]byte("0123456789abcdef0123456789abcdef")))
+	defer conf.SetKeyProvider(nil)
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	checkProvenance(map[string]string{
+		"PollMs":             "file:2",
+		"Devices.0.Password": "migrated:7",
+	})
+	if err := conf.ConvertConfig(conf.ConfigFormat_TOML); err != nil {
+		t.Fatal(err)
+	}
+	checkProvenance(map[string]string{
+		"PollMs":       "migrated:0",
+		"Devices.0.IP": "migrated:0",
+		"Retries":      "flag:0",
+	})
+}
+
+func TestEncryptedPasswords(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP       string
+		Password string `ibValidate:"password"`
+	}
+
+	type Config struct {
+		Devices []DeviceConfig
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-encrypted")
+	legacy := "[[Devices]]\nDeviceID = 1\nIP = \"10.0.0.1\"\nPassword = \"secret\" # camera login\n"
+	if err := os.WriteFile(coreName+".toml", []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetKeyProvider(conf.StaticKey([]byte("0123456789abcdef0123456789abcdef")))
+	defer conf.SetKeyProvider(nil)
+
+	var config Config
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Devices[0].Password != "secret" {
+		t.Fatalf("expected plain text legacy password to load, got %q", config.Devices[0].Password)
+	}
+
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(coreName + ".toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "secret") || !strings.Contains(string(saved), `Password = "enc:v1:`) || !strings.Contains(string(saved), "# camera login") {
+		t.Fatalf("expected encrypted password on save, got:\n%s", saved)
+	}
+
+	config = Config{}
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Devices[0].Password != "secret" {
+		t.Errorf("expected decrypted password, got %q", config.Devices[0].Password)
+	}
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	if resaved, _ := os.ReadFile(coreName + ".toml"); string(resaved) != string(saved) {
+		t.Errorf("expected unchanged password to keep its encrypted value, got:\n%s", resaved)
+	}
+
+	// Encrypted values are kept per field, other fields with the same password get their own
+	config.Devices = append(config.Devices, DeviceConfig{BaseDeviceConfig: conf.BaseDeviceConfig{DeviceID: 2}, Password: "secret"})
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	var encrypted Config
+	if _, err := toml.DecodeFile(coreName+".toml", &encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if len(encrypted.Devices) != 2 || !strings.Contains(string(saved), encrypted.Devices[0].Password) || encrypted.Devices[1].Password == encrypted.Devices[0].Password || !strings.HasPrefix(encrypted.Devices[1].Password, "enc:v1:") {
+		t.Errorf("expected a new encrypted value for the second device, got %+v", encrypted.Devices)
+	}
+
+	conf.SetKeyProvider(nil)
+	if err := conf.Load(&Config{}); err == nil {
+		t.Errorf("expected load of encrypted config without key provider to fail")
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		Password string `ibValidate:"password"`
+	}
+
+	type Config struct {
+		Devices []DeviceConfig
+	}
+
+	dir := t.TempDir()
+	coreName := filepath.Join(dir, "core-references")
+	secretFile := filepath.Join(dir, "cam1")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CAM2_PASS", "from-env")
+	original := fmt.Sprintf("[[Devices]]\nDeviceID = 1\nPassword = %q\n\n[[Devices]]\nDeviceID = 2\nPassword = \"env:CAM2_PASS\"\n", "file:"+secretFile)
+	if err := os.WriteFile(coreName+".toml", []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	var config Config
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Devices[0].Password != "from-file" || config.Devices[1].Password != "from-env" {
+		t.Fatalf("expected resolved secrets, got %+v", config.Devices)
+	}
+
+	config.Devices[1].Password = "changed"
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(coreName + ".toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(saved), "file:"+secretFile) || !strings.Contains(string(saved), `Password = "changed"`) || strings.Contains(string(saved), "from-file") {
+		t.Errorf("expected unchanged reference to be written back, got:\n%s", saved)
+	}
+
+	// References stay with their device when elements move and are never written into other fields with the same secret
+	config.Devices[0], config.Devices[1] = config.Devices[1], config.Devices[0]
+	config.Devices[0].Password = "from-file"
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	var savedConfig Config
+	if _, err := toml.DecodeFile(coreName+".toml", &savedConfig); err != nil {
+		t.Fatal(err)
+	}
+	if len(savedConfig.Devices) != 2 || savedConfig.Devices[0].DeviceID != 2 || savedConfig.Devices[0].Password != "from-file" || savedConfig.Devices[1].Password != "file:"+secretFile {
+		t.Errorf("expected reference to follow device 1 only, got %+v", savedConfig.Devices)
+	}
+
+	schema := conf.GetSchema(&config)
+	for value, valid := range map[string]bool{"env:CAM2_PASS": true, "file:/run/secrets/cam1": true, "plain": true, "env:": false, "env:2CAM": false, "file:": false} {
+		_, err := conf.ValidateConfig(schema, map[string]interface{}{"Devices": []interface{}{map[string]interface{}{"DeviceID": 1, "Password": value}}}, false, "test")
+		if (err == nil) != valid {
+			t.Errorf("expected validation of %q to be %v, got %v", value, valid, err)
+		}
+	}
+}
+
+func TestLiteralSecretReferences(t *testing.T) {
+	type Config struct {
+		Password  string `ibValidate:"password"`
+		Password2 string `ibValidate:"password"`
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-literal-references")
+	if err := os.WriteFile(coreName+".toml", []byte("Password = '\\env:my pass'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	var config Config
+	if err := conf.Load(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "env:my pass" {
+		t.Fatalf("expected escaped password to be loaded as literal, got %q", config.Password)
+	}
+
+	config.Password2 = "file:not-a-file" // Set by the core, saved as literal
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	var saved Config
+	if _, err := toml.DecodeFile(coreName+".toml", &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Password != `\env:my pass` || saved.Password2 != `\file:not-a-file` {
+		t.Errorf("expected literal passwords to be saved escaped, got %+v", saved)
+	}
+	var reloaded Config
+	if err := conf.Load(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded != config {
+		t.Errorf("expected %+v after reload, got %+v", config, reloaded)
+	}
+
+	if _, err := conf.ValidateConfig(conf.GetSchema(&config), map[string]interface{}{"Password": `\env:my pass`}, false, "test"); err != nil {
+		t.Errorf("expected escaped password to be valid, got %v", err)
+	}
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	type Config struct {
+		PollMs int
+	}
+
+	coreName := filepath.Join(t.TempDir(), "core-integrity")
+	conf.SetDevMode(true)
+	conf.SetCoreName(coreName)
+	conf.SetIntegrityCheck(true)
+	defer conf.SetIntegrityCheck(false)
+
+	config := Config{PollMs: 100}
+	if _, err := conf.LoadWithReport(&config); err != nil {
+		t.Fatal(err)
+	}
+	config.PollMs = 250
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{coreName + ".toml.sha256", coreName + ".toml.good"} {
+		if _, err := os.Stat(file); err != nil {
+			t.Errorf("expected %s to be written: %v", file, err)
+		}
+	}
+
+	report, err := conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Integrity != conf.IntegrityStatus_Valid {
+		t.Errorf("expected valid config, got %s", report.Integrity)
+	}
+
+	if info, err := os.Stat(coreName + ".toml.good"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected backup to be only readable by the owner, got %v %v", info, err)
+	}
+
+	// Mangled config is used with a warning by default
+	if err := os.WriteFile(coreName+".toml", []byte("PollMs = 9"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Integrity != conf.IntegrityStatus_Mismatch || config.PollMs != 9 {
+		t.Errorf("expected mismatching config to be used, got %s with %+v", report.Integrity, config)
+	}
+
+	// Cores can opt into falling back to the backup
+	conf.SetIntegrityPolicy(conf.FallBackIntegrityPolicy)
+	defer conf.SetIntegrityPolicy(nil)
+	report, err = conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Integrity != conf.IntegrityStatus_Restored || config.PollMs != 250 {
+		t.Errorf("expected backup to be restored, got %s with %+v", report.Integrity, config)
+	}
+	if corrupt, _ := os.ReadFile(coreName + ".toml.corrupt"); string(corrupt) != "PollMs = 9" {
+		t.Errorf("expected mangled config to be kept, got %q", corrupt)
+	}
+
+	// The policy can accept changes
+	var checked conf.IntegrityStatus
+	conf.SetIntegrityPolicy(func(file string, status conf.IntegrityStatus) conf.IntegrityAction {
+		checked = status
+		return conf.IntegrityAction_Accept
+	})
+	if err := os.WriteFile(coreName+".toml", []byte("PollMs = 9"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err = conf.LoadWithReport(&config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != conf.IntegrityStatus_Mismatch || report.Integrity != conf.IntegrityStatus_Mismatch || config.PollMs != 9 {
+		t.Errorf("expected accepted config, got %s with %+v", report.Integrity, config)
+	}
+
+	// A plain checksum does not pass when a device key is used
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	conf.SetIntegrityKey(conf.StaticKey([]byte("device key")))
+	defer conf.SetIntegrityKey(nil)
+	if report, err = conf.LoadWithReport(&config); err != nil || report.Integrity != conf.IntegrityStatus_Mismatch {
+		t.Errorf("expected sha256 checksum to fail with hmac key, got %v %v", report, err)
+	}
+	if err := conf.Save(&config); err != nil {
+		t.Fatal(err)
+	}
+	if report, err = conf.LoadWithReport(&config); err != nil || report.Integrity != conf.IntegrityStatus_Valid {
+		t.Errorf("expected valid hmac checksum, got %v %v", report, err)
+	}
+}
@@ -18,23 +18,48 @@ const (
 	ValueType_Password
 	ValueType_Select
 	ValueType_UniqueInc
+	ValueType_IntegerSelect
+	ValueType_MultiSelect
 )
 
+// Model is an entry of the model catalog of a core
+type Model struct {
+	ID           int
+	Name         string
+	Description  string   `json:",omitempty"`
+	Capabilities []string `json:",omitempty"` // Flags like "ptz" that ibOnlyOnModel and ibNotOnModel can reference instead of model ids
+}
+
+// Preset is a named partial element of a structure array, eg. a device with the settings of a common setup
+type Preset struct {
+	Name        string
+	Description string                 `json:",omitempty"`
+	Values      map[string]interface{} // Values of the fields of the element by their name in the schema
+}
+
 type ValueTypeDescriptor struct {
 	Type            ValueType
-	Label           string      `json:",omitempty"`
-	Description     string      `json:",omitempty"`
-	Options         []string    `json:",omitempty"`
-	Order           int         `json:",omitempty"`
-	DispatchOptions []string    `json:",omitempty"`
-	Default         interface{} `json:",omitempty"` // Provide a default value
-	Required        string      `json:",omitempty"` // Provide a message to show if this field is not filled
-	Hidden          string      `json:",omitempty"` // hide this, should match "true"
+	Label           string              `json:",omitempty"`
+	Description     string              `json:",omitempty"`
+	Options         []string            `json:",omitempty"`
+	Order           int                 `json:",omitempty"`
+	DispatchOptions []string            `json:",omitempty"`
+	Default         interface{}         `json:",omitempty"` // Provide a default value
+	DefaultOnModel  map[int]interface{} `json:",omitempty"` // Defaults of new structure array elements by model id, overriding Default
+	Required        string              `json:",omitempty"` // Provide a message to show if this field is not filled
+	Hidden          string              `json:",omitempty"` // hide this, should match "true"
+	Secret          bool                `json:",omitempty"` // replaced by a placeholder in redacted exports
 
 	Headline string `json:",omitempty"` // Add a headline before
 
-	OnlyOnModel []int `json:",omitempty"`
-	NotOnModel  []int `json:",omitempty"`
+	OnlyOnModel []int   `json:",omitempty"`
+	NotOnModel  []int   `json:",omitempty"`
+	Models      []Model `json:",omitempty"` // Catalog of the models a modelid field can be set to
+
+	Presets []Preset `json:",omitempty"` // Presets for new elements of a structure array
+
+	MinItems int `json:",omitempty"` // Minimum number of elements of an array, 0 for no limit
+	MaxItems int `json:",omitempty"` // Maximum number of elements of an array, 0 for no limit
 
 	ArraySubType      *ValueTypeDescriptor            `json:",omitempty"`
 	StructureSubtypes map[string]*ValueTypeDescriptor `json:",omitempty"`
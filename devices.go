@@ -0,0 +1,283 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// Dispatch roles of fields for reactor, set with the ibDispatch tag
+const (
+	Dispatch_Devices     = "devices"
+	Dispatch_Active      = "active"
+	Dispatch_ModelID     = "modelid"
+	Dispatch_DeviceID    = "deviceid"
+	Dispatch_Name        = "name"
+	Dispatch_Description = "description"
+	Dispatch_DeviceIP    = "deviceip"
+	Dispatch_IP          = "ip"
+	Dispatch_Optional    = "optional" // Combined with ip, devices without IP do not report a missing IP
+	Dispatch_Port        = "port"
+)
+
+// Device is an element of the device array of a config, with its fields resolved through the ibDispatch tags of the schema.
+// Fields without a dispatch role in the schema keep their zero value
+type Device struct {
+	ID          uint32
+	ModelID     uint32
+	Active      bool
+	Name        string
+	Description string
+	IP          string
+	IPOptional  bool // The IP field is tagged ip,optional
+	Port        int
+}
+
+// Devices returns the devices of a config. The config can be a (pointer to a) config structure or the generic map used by ValidateConfig.
+// The device array is found with DeviceArray, devices are returned in the order of the array
+func Devices(config interface{}, schema *cs.ValueTypeDescriptor) ([]Device, error) {
+	doc, err := configDocument(config)
+	if err != nil {
+		return nil, fmt.Errorf("on reading devices: %w", err)
+	}
+	path, vtd := DeviceArray(schema)
+	if vtd == nil {
+		return nil, fmt.Errorf("schema has no device array")
+	}
+
+	var value interface{} = doc
+	for _, name := range path {
+		table, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s is no structure", strings.Join(path, "."))
+		}
+		if value, ok = table[documentKey(table, name)]; !ok {
+			return []Device{}, nil
+		}
+	}
+
+	var elements []map[string]interface{}
+	switch v := value.(type) {
+	case []map[string]interface{}:
+		elements = v
+	case []interface{}:
+		for i, element := range v {
+			table, ok := element.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("device %d is no structure", i)
+			}
+			elements = append(elements, table)
+		}
+	default:
+		return nil, fmt.Errorf("%s is no array", strings.Join(path, "."))
+	}
+
+	fields := map[string]string{
+		Dispatch_DeviceID:    DispatchField(vtd, Dispatch_DeviceID),
+		Dispatch_ModelID:     DispatchField(vtd, Dispatch_ModelID),
+		Dispatch_Active:      DispatchField(vtd, Dispatch_Active),
+		Dispatch_Name:        DispatchField(vtd, Dispatch_Name),
+		Dispatch_Description: DispatchField(vtd, Dispatch_Description),
+		Dispatch_IP:          DispatchField(vtd, Dispatch_DeviceIP, Dispatch_IP),
+		Dispatch_Port:        DispatchField(vtd, Dispatch_Port),
+	}
+	ipOptional := fields[Dispatch_IP] != "" && containsString(vtd.StructureSubtypes[fields[Dispatch_IP]].DispatchOptions, Dispatch_Optional)
+
+	devices := make([]Device, len(elements))
+	for i, element := range elements {
+		device := Device{IPOptional: ipOptional}
+		for role, field := range fields {
+			if field == "" {
+				continue
+			}
+			value, ok := element[documentKey(element, field)]
+			if !ok || value == nil {
+				continue
+			}
+
+			if !device.set(role, value) {
+				return nil, fmt.Errorf("%s of device %d has an invalid type %T", field, i, value)
+			}
+		}
+		devices[i] = device
+	}
+	return devices, nil
+}
+
+// set sets the field of a dispatch role, it returns false if the value has the wrong type
+func (d *Device) set(role string, value interface{}) (ok bool) {
+	switch role {
+	case Dispatch_DeviceID, Dispatch_ModelID, Dispatch_Port:
+		var number int
+		if number, ok = intType(value); !ok {
+			return false
+		}
+		switch role {
+		case Dispatch_DeviceID:
+			d.ID = uint32(number)
+		case Dispatch_ModelID:
+			d.ModelID = uint32(number)
+		default:
+			d.Port = number
+		}
+	case Dispatch_Active:
+		d.Active, ok = value.(bool)
+	case Dispatch_Name:
+		d.Name, ok = value.(string)
+	case Dispatch_Description:
+		d.Description, ok = value.(string)
+	case Dispatch_IP:
+		d.IP, ok = value.(string)
+	}
+	return ok
+}
+
+// DeviceArray returns the path and schema of the structure array holding the devices: the one tagged ibDispatch:"devices" (or named Devices),
+// also inside of structures. The schema is nil if there is none
+func DeviceArray(schema *cs.ValueTypeDescriptor) ([]string, *cs.ValueTypeDescriptor) {
+	if schema == nil {
+		return nil, nil
+	}
+	names := make([]string, 0, len(schema.StructureSubtypes))
+	for name := range schema.StructureSubtypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vtd := schema.StructureSubtypes[name]
+		if vtd == nil {
+			continue
+		}
+		if vtd.Type == cs.ValueType_StructureArray && (containsString(vtd.DispatchOptions, Dispatch_Devices) || strings.ToLower(name) == Dispatch_Devices) {
+			return []string{name}, vtd
+		}
+		if vtd.Type == cs.ValueType_Structure {
+			if path, found := DeviceArray(vtd); found != nil {
+				return append([]string{name}, path...), found
+			}
+		}
+	}
+	return nil, nil
+}
+
+// DispatchField returns the name of the first field of a structure (array) with one of the dispatch roles, in order of the roles.
+// It returns an empty string if no field has one of them
+func DispatchField(vtd *cs.ValueTypeDescriptor, roles ...string) string {
+	names := make([]string, 0, len(vtd.StructureSubtypes))
+	for name := range vtd.StructureSubtypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, role := range roles {
+		for _, name := range names {
+			if sub := vtd.StructureSubtypes[name]; sub != nil && containsString(sub.DispatchOptions, role) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// dispatchTypes are the value types each dispatch role can be used on
+var dispatchTypes = map[string][]cs.ValueType{
+	Dispatch_Devices:     {cs.ValueType_StructureArray},
+	Dispatch_Active:      {cs.ValueType_Checkbox},
+	Dispatch_ModelID:     {cs.ValueType_Integer, cs.ValueType_IntegerSelect},
+	Dispatch_DeviceID:    {cs.ValueType_UniqueInc},
+	Dispatch_Name:        {cs.ValueType_String, cs.ValueType_Select},
+	Dispatch_Description: {cs.ValueType_String, cs.ValueType_Select},
+	Dispatch_DeviceIP:    {cs.ValueType_IP, cs.ValueType_String}, // Older cores tag plain strings and integers
+	Dispatch_IP:          {cs.ValueType_IP, cs.ValueType_String},
+	Dispatch_Port:        {cs.ValueType_Port, cs.ValueType_Integer, cs.ValueType_IntegerSelect},
+}
+
+// ValidateDispatch checks the ibDispatch tags of a schema: only known roles, each on a field of the required type,
+// all roles except devices only on fields of structure arrays and at most once per structure array (deviceip and ip count as the same role).
+// All problems are returned with the path of their field, schema generation fails on them
+func ValidateDispatch(schema *cs.ValueTypeDescriptor) []*ValidationError {
+	var errs []*ValidationError
+	validateDispatch(schema, "", &errs)
+	return errs
+}
+
+func validateDispatch(vtd *cs.ValueTypeDescriptor, path string, errs *[]*ValidationError) {
+	if vtd == nil {
+		return
+	}
+	report := func(path string, format string, a ...interface{}) {
+		*errs = append(*errs, &ValidationError{Path: path, Err: fmt.Errorf(format, a...)})
+	}
+
+	names := make([]string, 0, len(vtd.StructureSubtypes))
+	for name := range vtd.StructureSubtypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fieldsByRole := make(map[string][]string)
+	for _, name := range names {
+		sub := vtd.StructureSubtypes[name]
+		if sub == nil {
+			continue
+		}
+		fieldPath := joinPath(path, name)
+
+		for _, role := range sub.DispatchOptions {
+			if role == Dispatch_Optional {
+				if !containsString(sub.DispatchOptions, Dispatch_IP) && !containsString(sub.DispatchOptions, Dispatch_DeviceIP) {
+					report(fieldPath, "dispatch option %s can only be combined with %s", Dispatch_Optional, Dispatch_IP)
+				}
+				continue
+			}
+			types, known := dispatchTypes[role]
+			if !known {
+				report(fieldPath, "unknown dispatch role %q", role)
+				continue
+			}
+			if !containsValueType(types, sub.Type) {
+				report(fieldPath, "dispatch role %s can not be used on a %s field", role, valueTypeName(sub.Type))
+			}
+			if role == Dispatch_Devices {
+				continue
+			}
+			if vtd.Type != cs.ValueType_StructureArray {
+				report(fieldPath, "dispatch role %s can only be used on fields of a structure array", role)
+				continue
+			}
+			if role == Dispatch_DeviceIP {
+				role = Dispatch_IP
+			}
+			if !containsString(fieldsByRole[role], name) {
+				fieldsByRole[role] = append(fieldsByRole[role], name)
+			}
+		}
+
+		if sub.Type == cs.ValueType_Structure || sub.Type == cs.ValueType_StructureArray {
+			validateDispatch(sub, fieldPath, errs)
+		}
+	}
+
+	roles := make([]string, 0, len(fieldsByRole))
+	for role := range fieldsByRole {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	for _, role := range roles {
+		if fields := fieldsByRole[role]; len(fields) > 1 {
+			report(path, "dispatch role %s is used on several fields: %s", role, strings.Join(fields, ", "))
+		}
+	}
+}
+
+func containsValueType(types []cs.ValueType, t cs.ValueType) bool {
+	for _, candidate := range types {
+		if candidate == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package config_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+func TestDevices(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		Address string `ibValidate:"ip" ibDispatch:"ip,optional"`
+		Port    int    `ibValidate:"port" ibDispatch:"port"`
+	}
+	type Network struct {
+		Cameras []DeviceConfig `ibDispatch:"devices"`
+	}
+	type Config struct {
+		Network Network
+	}
+
+	config := Config{Network: Network{Cameras: []DeviceConfig{
+		{BaseDeviceConfig: conf.BaseDeviceConfig{Active: true, Name: "Cam 1", DeviceID: 1, ModelID: 3, Description: "Stage left"}, Address: "10.0.0.1", Port: 9910},
+		{BaseDeviceConfig: conf.BaseDeviceConfig{DeviceID: 3, ModelID: 7}},
+	}}}
+	schema := conf.GetSchema(&config)
+
+	expected := []conf.Device{
+		{ID: 1, ModelID: 3, Active: true, Name: "Cam 1", Description: "Stage left", IP: "10.0.0.1", IPOptional: true, Port: 9910},
+		{ID: 3, ModelID: 7, IPOptional: true},
+	}
+	devices, err := conf.Devices(&config, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("expected %v, got %v", expected, devices)
+	}
+
+	generic := map[string]interface{}{"network": map[string]interface{}{"cameras": []interface{}{
+		map[string]interface{}{"DeviceID": float64(1), "ModelID": int64(3), "Active": true, "Name": "Cam 1", "Description": "Stage left", "address": "10.0.0.1", "Port": 9910},
+		map[string]interface{}{"DeviceID": 3, "ModelID": 7},
+	}}}
+	if devices, err = conf.Devices(generic, schema); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("expected %v from generic config, got %v", expected, devices)
+	}
+
+	generic = map[string]interface{}{"Network": map[string]interface{}{"Cameras": []interface{}{map[string]interface{}{"DeviceID": "one"}}}}
+	if _, err := conf.Devices(generic, schema); err == nil {
+		t.Errorf("expected error for device id of wrong type")
+	}
+	if _, err := conf.Devices(map[string]interface{}{}, conf.GetSchema(&struct{ PollMs int }{})); err == nil {
+		t.Errorf("expected error for schema without device array")
+	}
+}
+
+func TestValidateDispatch(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP   string `ibValidate:"ip" ibDispatch:"deviceip"`
+		Port int    `ibValidate:"port" ibDispatch:"port"`
+	}
+	type Config struct {
+		Devices []DeviceConfig
+	}
+	if errs := conf.ValidateDispatch(conf.GetSchema(&Config{})); len(errs) != 0 {
+		t.Errorf("expected no problems, got %v", errs)
+	}
+
+	type OldDeviceConfig struct { // Tags of cores written before ibValidate
+		conf.BaseDeviceConfig
+		IP   string `ibDispatch:"deviceip"`
+		Port uint16 `ibDispatch:"port"`
+	}
+	type OldConfig struct {
+		Devices []OldDeviceConfig
+	}
+	if schema := conf.GetSchema(&OldConfig{}); schema == nil {
+		t.Errorf("expected a schema for old style dispatch tags")
+	} else if errs := conf.ValidateDispatch(schema); len(errs) != 0 {
+		t.Errorf("expected no problems for old style dispatch tags, got %v", errs)
+	}
+
+	schema := &cs.ValueTypeDescriptor{Type: cs.ValueType_Structure, StructureSubtypes: map[string]*cs.ValueTypeDescriptor{
+		"Active": {Type: cs.ValueType_Checkbox, DispatchOptions: []string{"active"}},
+		"Devices": {Type: cs.ValueType_StructureArray, DispatchOptions: []string{"devices"}, StructureSubtypes: map[string]*cs.ValueTypeDescriptor{
+			"DeviceID": {Type: cs.ValueType_UniqueInc, DispatchOptions: []string{"deviceid"}},
+			"ModelID":  {Type: cs.ValueType_String, DispatchOptions: []string{"modelid"}},
+			"IP":       {Type: cs.ValueType_IP, DispatchOptions: []string{"deviceip"}},
+			"IP2":      {Type: cs.ValueType_IP, DispatchOptions: []string{"ip", "optional"}},
+			"Name":     {Type: cs.ValueType_String, DispatchOptions: []string{"name", "optional"}},
+		}},
+		"Panels": {Type: cs.ValueType_StructureArray, DispatchOptions: []string{"devcies"}},
+	}}
+	var problems []string
+	for _, err := range conf.ValidateDispatch(schema) {
+		problems = append(problems, err.Error())
+	}
+	expected := []string{
+		"Active: dispatch role active can only be used on fields of a structure array",
+		"Devices.ModelID: dispatch role modelid can not be used on a string field",
+		"Devices.Name: dispatch option optional can only be combined with ip",
+		"Devices: dispatch role ip is used on several fields: IP, IP2",
+		`Panels: unknown dispatch role "devcies"`,
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Errorf("expected problems\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(problems, "\n"))
+	}
+}
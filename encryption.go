@@ -0,0 +1,216 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// encryptedPrefix marks encrypted values in config files, values without it are plain text
+const encryptedPrefix = "enc:v1:"
+
+// keySize is the size of the AES-256 keys returned by key providers
+const keySize = 32
+
+// KeyProvider returns the key used to encrypt password fields, it has to be 32 bytes long
+type KeyProvider func() ([]byte, error)
+
+var keyProvider KeyProvider
+
+type encryptedValue struct {
+	plain     string
+	encrypted string
+}
+
+// encryptedValues holds the current value of each password field by its path, so unchanged passwords are saved with the same encrypted value.
+// It is replaced on every Load, old values are dropped when a field changes
+var encryptedValues = make(map[string]encryptedValue)
+var encryptedValuesMu sync.Mutex
+
+// SetKeyProvider enables the encryption of password fields (ibValidate:"password") in the config file. They are encrypted on Save and decrypted on Load.
+// Plain text values of existing config files are encrypted on the next Save. nil disables the encryption
+func SetKeyProvider(provider KeyProvider) {
+	keyProvider = provider
+	encryptedValuesMu.Lock()
+	encryptedValues = make(map[string]encryptedValue)
+	encryptedValuesMu.Unlock()
+}
+
+// KeyFromFile reads a base64 encoded key from a file. A random key is created if the file does not exist yet
+func KeyFromFile(file string) KeyProvider {
+	return func() ([]byte, error) {
+		data, err := os.ReadFile(file)
+		if os.IsNotExist(err) {
+			key := make([]byte, keySize)
+			if _, err := rand.Read(key); err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+				return nil, err
+			}
+			return key, os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	}
+}
+
+// KeyFromEnv reads a base64 encoded key from an environment variable
+func KeyFromEnv(name string) KeyProvider {
+	return func() ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s is not set", name)
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	}
+}
+
+// StaticKey always returns the same key, eg. for tests
+func StaticKey(key []byte) KeyProvider {
+	return func() ([]byte, error) {
+		return key, nil
+	}
+}
+
+// encryptPasswords encrypts the values of all password fields in a toml document that are not encrypted yet
+func encryptPasswords(data []byte, schema *cs.ValueTypeDescriptor) ([]byte, error) {
+	if keyProvider == nil {
+		return data, nil
+	}
+	aead, err := newAEAD()
+	if err != nil {
+		return nil, err
+	}
+
+	return transformPasswords(data, schema, func(path, value string) (string, error) {
+		if value == "" || strings.HasPrefix(value, encryptedPrefix) || isSecretReference(value) {
+			return value, nil
+		}
+		encryptedValuesMu.Lock()
+		defer encryptedValuesMu.Unlock()
+		if current, ok := encryptedValues[path]; ok && current.plain == value {
+			return current.encrypted, nil
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		encrypted := encryptedPrefix + base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil))
+		encryptedValues[path] = encryptedValue{plain: value, encrypted: encrypted}
+		recordMigrated(path, value) // Plain text password of the config file
+		return encrypted, nil
+	})
+}
+
+// decryptPasswords decrypts the values of all password fields in a toml document, plain text values are kept
+func decryptPasswords(data []byte, schema *cs.ValueTypeDescriptor) ([]byte, error) {
+	values := make(map[string]encryptedValue)
+	defer func() {
+		encryptedValuesMu.Lock()
+		encryptedValues = values
+		encryptedValuesMu.Unlock()
+	}()
+	if !bytes.Contains(data, []byte(encryptedPrefix)) {
+		return data, nil
+	}
+
+	var aead cipher.AEAD
+	return transformPasswords(data, schema, func(path, value string) (string, error) {
+		if !strings.HasPrefix(value, encryptedPrefix) {
+			return value, nil
+		}
+		if aead == nil {
+			if keyProvider == nil {
+				return "", fmt.Errorf("%s is encrypted, but no key provider is set", path)
+			}
+			var err error
+			if aead, err = newAEAD(); err != nil {
+				return "", err
+			}
+		}
+
+		sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return "", fmt.Errorf("%s is no valid encrypted value", path)
+		}
+		plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return "", fmt.Errorf("on decrypting %s: %w", path, err)
+		}
+
+		values[path] = encryptedValue{plain: string(plain), encrypted: value}
+		return string(plain), nil
+	})
+}
+
+func newAEAD() (cipher.AEAD, error) {
+	key, err := keyProvider()
+	if err != nil {
+		return nil, fmt.Errorf("on getting encryption key: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key has %d bytes, expected %d", len(key), keySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// transformPasswords replaces the string values of all password fields in a toml document, everything else is kept as is
+func transformPasswords(data []byte, schema *cs.ValueTypeDescriptor, transform func(path, value string) (string, error)) ([]byte, error) {
+	doc := string(data)
+	entries, _, err := scanTOML(doc)
+	if err != nil {
+		return nil, fmt.Errorf("on scanning toml for passwords: %w", err)
+	}
+
+	var out strings.Builder
+	last := 0
+	for _, entry := range entries {
+		path := strings.ReplaceAll(entry.path, pathSeparator, ".")
+		if vtd := descriptorAtPath(schema, schemaPath(path)); vtd == nil || vtd.Type != cs.ValueType_Password {
+			continue
+		}
+		decoded := make(map[string]interface{})
+		if err := toml.Unmarshal([]byte("v = "+doc[entry.start:entry.end]), &decoded); err != nil {
+			continue
+		}
+		value, ok := decoded["v"].(string)
+		if !ok {
+			continue
+		}
+
+		transformed, err := transform(path, value)
+		if err != nil {
+			return nil, err
+		}
+		if transformed == value {
+			continue
+		}
+		encoded, err := encodeTOMLValue(transformed)
+		if err != nil {
+			return nil, err
+		}
+		out.WriteString(doc[last:entry.start])
+		out.WriteString(encoded)
+		last = entry.end
+	}
+	out.WriteString(doc[last:])
+	return []byte(out.String()), nil
+}
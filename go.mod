@@ -10,4 +10,5 @@ require (
 	github.com/s00500/env_logger v0.1.28
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	golang.org/x/sys v0.12.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
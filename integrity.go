@@ -0,0 +1,219 @@
+package config
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/s00500/env_logger"
+)
+
+// Extensions of the files written next to the config file in integrity mode
+const (
+	integrityExtension = ".sha256"  // Checksum of the config file
+	backupExtension    = ".good"    // Copy of the config file with the last valid checksum
+	corruptExtension   = ".corrupt" // Config file replaced by the backup
+)
+
+// Algorithms of the checksum in the integrity file
+const (
+	integritySHA256     = "sha256"
+	integrityHMACSHA256 = "hmac-sha256"
+)
+
+// IntegrityStatus is the result of checking the config file against its checksum on Load
+type IntegrityStatus = string
+
+// IntegrityStatuses reported in the LoadReport
+const (
+	IntegrityStatus_Valid    IntegrityStatus = "valid"
+	IntegrityStatus_Missing  IntegrityStatus = "missing"  // There is no checksum for the config file yet
+	IntegrityStatus_Mismatch IntegrityStatus = "mismatch" // The config file has been changed or damaged outside of Save
+	IntegrityStatus_Restored IntegrityStatus = "restored" // The config file did not match and has been replaced by the last known-good backup
+)
+
+// IntegrityAction is what Load does with a config file that did not pass the integrity check
+type IntegrityAction = string
+
+// IntegrityActions returned by an IntegrityPolicy
+const (
+	IntegrityAction_Accept   IntegrityAction = "accept"
+	IntegrityAction_Warn     IntegrityAction = "warn"      // Use the config file and log a warning
+	IntegrityAction_FallBack IntegrityAction = "fall back" // Use the last known-good backup, the config file is kept with a .corrupt extension
+)
+
+// IntegrityPolicy decides what to do with a config file that is missing a checksum or does not match it
+type IntegrityPolicy func(file string, status IntegrityStatus) IntegrityAction
+
+var integrityCheck bool = false
+var integrityKey KeyProvider
+var integrityPolicy IntegrityPolicy = DefaultIntegrityPolicy
+
+// SetIntegrityCheck enables the integrity mode: Save writes a checksum and a backup next to the config file, Load verifies the file against the checksum
+func SetIntegrityCheck(enabled bool) {
+	integrityCheck = enabled
+}
+
+// SetIntegrityKey uses an HMAC with a device key instead of a plain SHA-256 checksum, nil switches back to SHA-256
+func SetIntegrityKey(provider KeyProvider) {
+	integrityKey = provider
+}
+
+// SetIntegrityPolicy sets the policy deciding how Load handles config files that do not pass the integrity check
+func SetIntegrityPolicy(policy IntegrityPolicy) {
+	if policy == nil {
+		policy = DefaultIntegrityPolicy
+	}
+	integrityPolicy = policy
+}
+
+// DefaultIntegrityPolicy warns about missing checksums (eg. after enabling the integrity mode) and config files that do not match, they are used anyway
+func DefaultIntegrityPolicy(file string, status IntegrityStatus) IntegrityAction {
+	return IntegrityAction_Warn
+}
+
+// FallBackIntegrityPolicy warns about missing checksums and falls back to the backup if the config file does not match.
+// Cores opt into it with SetIntegrityPolicy(config.FallBackIntegrityPolicy)
+func FallBackIntegrityPolicy(file string, status IntegrityStatus) IntegrityAction {
+	if status == IntegrityStatus_Mismatch {
+		return IntegrityAction_FallBack
+	}
+	return IntegrityAction_Warn
+}
+
+// writeUserFile writes the config file of the core, in integrity mode together with its checksum and backup
+func writeUserFile(file string, data []byte) error {
+	if err := writeConfigFile(file, data); err != nil {
+		return err
+	}
+	if !integrityCheck {
+		return nil
+	}
+	return writeIntegrity(file, data)
+}
+
+// writeIntegrity writes the checksum and the backup of a config file
+func writeIntegrity(file string, data []byte) error {
+	checksum, err := integrityChecksum(data)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(file+integrityExtension, []byte(checksum+"\n"), 0600); err != nil {
+		return fmt.Errorf("on writing checksum: %w", err)
+	}
+	return writeBackupFile(file+backupExtension, data)
+}
+
+// writeBackupFile writes a copy of the config file, only readable by the owner like the checksum
+func writeBackupFile(file string, data []byte) error {
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		return fmt.Errorf("on writing backup: %w", err)
+	}
+	if err := os.Chmod(file, 0600); err != nil { // Backups of older versions were readable by everyone
+		return fmt.Errorf("on writing backup: %w", err)
+	}
+	return nil
+}
+
+// UpdateIntegrity rewrites the checksum and backup of a config file changed by another tool (eg. ibconfig), so it does not count as tampered.
+// HMAC checksums can only be updated with the integrity key set
+func UpdateIntegrity(file string, data []byte) error {
+	if err := CheckIntegrityUpdate(file); err != nil {
+		return err
+	}
+	return writeIntegrity(file, data)
+}
+
+// CheckIntegrityUpdate returns an error if UpdateIntegrity can not update the checksum of a config file, tools call it before writing the file
+func CheckIntegrityUpdate(file string) error {
+	if stored, err := os.ReadFile(file + integrityExtension); err == nil && strings.HasPrefix(string(stored), integrityHMACSHA256+" ") && integrityKey == nil {
+		return fmt.Errorf("checksum of %s is an hmac, but no integrity key is set", file)
+	}
+	return nil
+}
+
+// checkIntegrity verifies a config file against its checksum and applies the policy, it returns the data to load
+func checkIntegrity(file string, data []byte) ([]byte, IntegrityStatus, error) {
+	if !integrityCheck {
+		return data, "", nil
+	}
+
+	status, err := verifyIntegrity(file, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if status == IntegrityStatus_Valid {
+		if backup, err := os.ReadFile(file + backupExtension); err != nil || !bytes.Equal(backup, data) {
+			log.Should(writeBackupFile(file+backupExtension, data))
+		}
+		return data, status, nil
+	}
+
+	switch integrityPolicy(file, status) {
+	case IntegrityAction_Accept:
+		return data, status, nil
+	case IntegrityAction_FallBack:
+		backup, err := os.ReadFile(file + backupExtension)
+		if err == nil {
+			var backupStatus IntegrityStatus
+			if backupStatus, err = verifyIntegrity(file, backup); err == nil && backupStatus != IntegrityStatus_Valid {
+				err = fmt.Errorf("backup does not match the checksum either")
+			}
+		}
+		if err != nil {
+			log.Errorf("config file %s is %s, can not fall back to the backup: %v", file, status, err)
+			return data, status, nil
+		}
+
+		log.Warnf("config file %s is %s, restoring the last known-good backup", file, status)
+		if err := writeBackupFile(file+corruptExtension, data); err != nil {
+			return nil, "", err
+		}
+		if err := writeConfigFile(file, backup); err != nil {
+			return nil, "", err
+		}
+		return backup, IntegrityStatus_Restored, nil
+	default:
+		log.Warnf("config file %s failed the integrity check: checksum is %s", file, status)
+		return data, status, nil
+	}
+}
+
+func verifyIntegrity(file string, data []byte) (IntegrityStatus, error) {
+	stored, err := os.ReadFile(file + integrityExtension)
+	if os.IsNotExist(err) {
+		return IntegrityStatus_Missing, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("on reading checksum: %w", err)
+	}
+
+	checksum, err := integrityChecksum(data)
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(strings.TrimSpace(string(stored))), []byte(checksum)) { // Also fails if the algorithm does not match the key
+		return IntegrityStatus_Mismatch, nil
+	}
+	return IntegrityStatus_Valid, nil
+}
+
+// integrityChecksum returns the checksum of a config file with the name of its algorithm, eg. "sha256 9f86d08..."
+func integrityChecksum(data []byte) (string, error) {
+	if integrityKey == nil {
+		sum := sha256.Sum256(data)
+		return integritySHA256 + " " + hex.EncodeToString(sum[:]), nil
+	}
+
+	key, err := integrityKey()
+	if err != nil {
+		return "", fmt.Errorf("on getting integrity key: %w", err)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return integrityHMACSHA256 + " " + hex.EncodeToString(mac.Sum(nil)), nil
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// JSONSchemaDraft is the JSON Schema dialect used by ExportJSONSchema
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// ExportJSONSchema converts a schema into a JSON Schema (draft 2020-12) document
+// Properties without a JSON Schema equivalent are exported as x-ib-* extensions
+func ExportJSONSchema(schema *cs.ValueTypeDescriptor) ([]byte, error) {
+	return json.MarshalIndent(ToJSONSchema(schema), "", "  ")
+}
+
+// ToJSONSchema converts a schema into a JSON Schema (draft 2020-12) object
+func ToJSONSchema(schema *cs.ValueTypeDescriptor) map[string]interface{} {
+	jsonSchema := jsonSchemaNode(schema)
+	jsonSchema["$schema"] = JSONSchemaDraft
+	return jsonSchema
+}
+
+func jsonSchemaNode(vtd *cs.ValueTypeDescriptor) map[string]interface{} {
+	node := make(map[string]interface{})
+	if vtd == nil {
+		return node
+	}
+
+	if vtd.Label != "" {
+		node["title"] = vtd.Label
+	}
+	if vtd.Description != "" {
+		node["description"] = vtd.Description
+	}
+	if vtd.Default != nil {
+		node["default"] = vtd.Default
+	}
+	if len(vtd.DefaultOnModel) > 0 {
+		defaults := make(map[string]interface{}, len(vtd.DefaultOnModel))
+		for model, def := range vtd.DefaultOnModel {
+			defaults[strconv.Itoa(model)] = def
+		}
+		node["x-ib-default-on-model"] = defaults
+	}
+	if vtd.Order != 0 {
+		node["x-ib-order"] = vtd.Order
+	}
+	if len(vtd.DispatchOptions) > 0 {
+		node["x-ib-dispatch"] = vtd.DispatchOptions
+	}
+	if len(vtd.OnlyOnModel) > 0 {
+		node["x-ib-only-on-model"] = vtd.OnlyOnModel
+	}
+	if len(vtd.NotOnModel) > 0 {
+		node["x-ib-not-on-model"] = vtd.NotOnModel
+	}
+	if len(vtd.Models) > 0 {
+		node["x-ib-models"] = vtd.Models
+	}
+	if len(vtd.Presets) > 0 {
+		node["x-ib-presets"] = vtd.Presets
+	}
+	if vtd.MinItems > 0 {
+		node["minItems"] = vtd.MinItems
+	}
+	if vtd.MaxItems > 0 {
+		node["maxItems"] = vtd.MaxItems
+	}
+	if vtd.Hidden != "" {
+		node["x-ib-hidden"] = vtd.Hidden
+	}
+	if vtd.Secret {
+		node["x-ib-secret"] = true
+	}
+	if vtd.Headline != "" {
+		node["x-ib-headline"] = vtd.Headline
+	}
+	if vtd.Required != "" {
+		node["x-ib-required"] = vtd.Required
+	}
+
+	switch vtd.Type {
+	case cs.ValueType_Integer:
+		node["type"] = "integer"
+	case cs.ValueType_Float:
+		node["type"] = "number"
+	case cs.ValueType_String:
+		node["type"] = "string"
+	case cs.ValueType_Port:
+		node["type"] = "integer"
+		node["minimum"] = 0
+		node["maximum"] = 65535
+		node["x-ib-type"] = "port"
+	case cs.ValueType_IP:
+		node["type"] = "string" // No format here, hostnames are valid as well
+		node["x-ib-type"] = "ip"
+	case cs.ValueType_Checkbox:
+		node["type"] = "boolean"
+	case cs.ValueType_Structure:
+		objectNode(node, vtd.StructureSubtypes)
+	case cs.ValueType_Array:
+		node["type"] = "array"
+		if vtd.ArraySubType != nil {
+			node["items"] = jsonSchemaNode(vtd.ArraySubType)
+		}
+	case cs.ValueType_StructureArray:
+		items := make(map[string]interface{})
+		objectNode(items, vtd.StructureSubtypes)
+		node["type"] = "array"
+		node["items"] = items
+	case cs.ValueType_Password:
+		node["type"] = "string"
+		node["format"] = "password"
+		node["writeOnly"] = true
+	case cs.ValueType_Select:
+		node["type"] = "string"
+		node["enum"] = vtd.Options
+	case cs.ValueType_IntegerSelect:
+		options := make([]int, 0, len(vtd.Options))
+		for _, option := range vtd.Options {
+			if num, err := strconv.Atoi(option); err == nil {
+				options = append(options, num)
+			}
+		}
+		node["type"] = "integer"
+		node["enum"] = options
+	case cs.ValueType_MultiSelect:
+		node["type"] = "array"
+		node["items"] = map[string]interface{}{"type": "string", "enum": vtd.Options}
+		node["uniqueItems"] = true
+	case cs.ValueType_UniqueInc:
+		node["type"] = "integer"
+		node["minimum"] = 0
+		node["x-ib-type"] = "unique_inc"
+	}
+
+	if vtd.Required != "" && node["type"] == "string" {
+		node["minLength"] = 1
+	}
+
+	return node
+}
+
+func objectNode(node map[string]interface{}, subtypes map[string]*cs.ValueTypeDescriptor) {
+	properties := make(map[string]interface{})
+	required := make([]string, 0)
+	for name, subtype := range subtypes {
+		if subtype == nil {
+			continue
+		}
+		properties[name] = jsonSchemaNode(subtype)
+		if subtype.Required != "" {
+			required = append(required, name)
+		}
+	}
+	sort.Strings(required)
+
+	node["type"] = "object"
+	node["properties"] = properties
+	if len(required) > 0 {
+		node["required"] = required
+	}
+}
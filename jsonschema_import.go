@@ -0,0 +1,445 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// defaultRequiredMessage is used for fields listed as required in a JSON Schema without an x-ib-required message
+const defaultRequiredMessage = "This field is required"
+
+// ImportJSONSchema parses a JSON Schema document and converts it into a schema that can be used with ValidateConfig
+// Keywords that can not be represented are returned as a list of "<json pointer>: <keyword>" entries
+func ImportJSONSchema(data []byte) (schema *cs.ValueTypeDescriptor, unsupported []string, err error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, nil, fmt.Errorf("on decoding json schema: %w", err)
+	}
+	return FromJSONSchema(root)
+}
+
+// FromJSONSchema converts a decoded JSON Schema document into a schema, see ImportJSONSchema
+func FromJSONSchema(root map[string]interface{}) (schema *cs.ValueTypeDescriptor, unsupported []string, err error) {
+	imp := &jsonSchemaImporter{root: root}
+	schema, err = imp.node(root, "#", nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if schema.Type != cs.ValueType_Structure {
+		return nil, nil, fmt.Errorf("json schema root is no object")
+	}
+
+	sort.Strings(imp.unsupported)
+	return schema, imp.unsupported, nil
+}
+
+type jsonSchemaImporter struct {
+	root        map[string]interface{}
+	unsupported []string
+}
+
+// handledKeywords are read while importing a node, everything else is reported as unsupported
+var handledKeywords = []string{
+	"$schema", "$id", "$defs", "definitions", "$ref", "$comment",
+	"title", "description", "default", "examples", "type", "properties", "required", "items", "enum", "format", "writeOnly",
+	"minimum", "maximum", "minLength", "uniqueItems", "minItems", "maxItems",
+	"x-ib-type", "x-ib-default-on-model", "x-ib-order", "x-ib-dispatch", "x-ib-only-on-model", "x-ib-not-on-model", "x-ib-models", "x-ib-presets", "x-ib-hidden", "x-ib-headline", "x-ib-required", "x-ib-secret",
+}
+
+func (imp *jsonSchemaImporter) report(pointer, keyword string) {
+	imp.unsupported = append(imp.unsupported, pointer+": "+keyword)
+}
+
+func (imp *jsonSchemaImporter) resolve(ref string, refStack []string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("only local references are supported, got %q", ref)
+	}
+	for _, r := range refStack {
+		if r == ref {
+			return nil, fmt.Errorf("recursive reference %q can not be represented", ref)
+		}
+	}
+
+	var current interface{} = imp.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("could not resolve reference %q", ref)
+		}
+		current, ok = object[token]
+		if !ok {
+			return nil, fmt.Errorf("could not resolve reference %q", ref)
+		}
+	}
+
+	node, ok := current.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("reference %q is no schema object", ref)
+	}
+	return node, nil
+}
+
+func (imp *jsonSchemaImporter) node(node map[string]interface{}, pointer string, refStack []string) (*cs.ValueTypeDescriptor, error) {
+	if ref, ok := node["$ref"].(string); ok {
+		target, err := imp.resolve(ref, refStack)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pointer, err)
+		}
+		vtd, err := imp.node(target, ref, append(refStack, ref))
+		if err != nil {
+			return nil, err
+		}
+		// Annotations next to a reference override the ones of the target
+		if title, ok := node["title"].(string); ok {
+			vtd.Label = title
+		}
+		if description, ok := node["description"].(string); ok {
+			vtd.Description = description
+		}
+		imp.annotations(vtd, node)
+		return vtd, nil
+	}
+
+	for keyword := range node {
+		if !containsString(handledKeywords, keyword) {
+			imp.report(pointer, keyword)
+		}
+	}
+
+	vtd := new(cs.ValueTypeDescriptor)
+	vtd.Label, _ = node["title"].(string)
+	vtd.Description, _ = node["description"].(string)
+	imp.annotations(vtd, node)
+
+	typeName, err := jsonSchemaType(node)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", pointer, err)
+	}
+	ibType, _ := node["x-ib-type"].(string)
+	format, _ := node["format"].(string)
+	options := jsonSchemaEnum(node["enum"])
+
+	switch typeName {
+	case "integer":
+		switch {
+		case options != nil:
+			vtd.Type = cs.ValueType_IntegerSelect
+			vtd.Options = options
+		case ibType == "port":
+			vtd.Type = cs.ValueType_Port
+		case ibType == "unique_inc":
+			vtd.Type = cs.ValueType_UniqueInc
+		default:
+			vtd.Type = cs.ValueType_Integer
+			imp.reportRange(node, pointer)
+		}
+		if def, ok := node["default"].(float64); ok && vtd.Type != cs.ValueType_UniqueInc {
+			vtd.Default = int(def)
+		}
+		for model, def := range vtd.DefaultOnModel {
+			if num, ok := def.(float64); ok {
+				vtd.DefaultOnModel[model] = int(num)
+			}
+		}
+		if format != "" {
+			imp.report(pointer, "format")
+		}
+
+	case "number":
+		vtd.Type = cs.ValueType_Float
+		vtd.Default = node["default"]
+		imp.reportRange(node, pointer)
+		if format != "" {
+			imp.report(pointer, "format")
+		}
+
+	case "string":
+		switch {
+		case options != nil:
+			vtd.Type = cs.ValueType_Select
+			vtd.Options = options
+		case format == "password" || node["writeOnly"] == true && node["x-ib-secret"] != true: // Secrets used to be exported as writeOnly too
+			vtd.Type = cs.ValueType_Password
+		case ibType == "ip" || format == "ipv4" || format == "ipv6" || format == "hostname":
+			vtd.Type = cs.ValueType_IP
+		default:
+			vtd.Type = cs.ValueType_String
+			if format != "" {
+				imp.report(pointer, "format")
+			}
+		}
+		vtd.Default = node["default"]
+		if minLength, ok := node["minLength"].(float64); ok {
+			if minLength != 1 {
+				imp.report(pointer, "minLength")
+			} else if vtd.Required == "" {
+				vtd.Required = defaultRequiredMessage // A string that may not be empty is what ibRequired is used for
+			}
+		}
+
+	case "boolean":
+		vtd.Type = cs.ValueType_Checkbox
+		vtd.Default = node["default"]
+
+	case "object":
+		vtd.Type = cs.ValueType_Structure
+		vtd.StructureSubtypes, err = imp.properties(node, pointer, refStack)
+		if err != nil {
+			return nil, err
+		}
+
+	case "array":
+		if minItems, ok := node["minItems"].(float64); ok {
+			vtd.MinItems = int(minItems)
+		}
+		if maxItems, ok := node["maxItems"].(float64); ok {
+			vtd.MaxItems = int(maxItems)
+		}
+		items, _ := node["items"].(map[string]interface{})
+		itemsPointer := pointer + "/items"
+		if ref, ok := items["$ref"].(string); ok {
+			target, err := imp.resolve(ref, refStack)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", itemsPointer, err)
+			}
+			if typeName, _ := jsonSchemaType(target); typeName == "object" {
+				items, itemsPointer, refStack = target, ref, append(refStack, ref)
+			}
+		}
+		if node["uniqueItems"] == true && (items == nil || items["enum"] == nil) {
+			imp.report(pointer, "uniqueItems")
+		}
+
+		itemType, _ := jsonSchemaType(items)
+		switch {
+		case items == nil:
+			vtd.Type = cs.ValueType_Array
+			vtd.ArraySubType = &cs.ValueTypeDescriptor{Type: cs.ValueType_Unknown}
+		case itemType == "object":
+			vtd.Type = cs.ValueType_StructureArray
+			for keyword := range items {
+				if !containsString([]string{"$comment", "type", "properties", "required", "title", "description"}, keyword) {
+					imp.report(itemsPointer, keyword)
+				}
+			}
+			vtd.StructureSubtypes, err = imp.properties(items, itemsPointer, refStack)
+			if err != nil {
+				return nil, err
+			}
+			vtd.Presets = jsonSchemaPresets(node["x-ib-presets"], vtd.StructureSubtypes)
+		case itemType == "string" && items["enum"] != nil:
+			vtd.Type = cs.ValueType_MultiSelect
+			vtd.Options = jsonSchemaEnum(items["enum"])
+			if def, ok := node["default"].([]interface{}); ok {
+				vtd.Default = jsonSchemaEnum(def)
+			}
+		default:
+			vtd.Type = cs.ValueType_Array
+			vtd.ArraySubType, err = imp.node(items, itemsPointer, refStack)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case "":
+		vtd.Type = cs.ValueType_Unknown
+		imp.report(pointer, "type")
+
+	default:
+		return nil, fmt.Errorf("%s: unknown type %q", pointer, typeName)
+	}
+
+	return vtd, nil
+}
+
+// annotations reads the x-ib-* extensions written by ExportJSONSchema
+func (imp *jsonSchemaImporter) annotations(vtd *cs.ValueTypeDescriptor, node map[string]interface{}) {
+	if order, ok := node["x-ib-order"].(float64); ok {
+		vtd.Order = int(order)
+	}
+	if defaults, ok := node["x-ib-default-on-model"].(map[string]interface{}); ok {
+		vtd.DefaultOnModel = make(map[int]interface{}, len(defaults))
+		for model, def := range defaults {
+			if num, err := strconv.Atoi(model); err == nil {
+				vtd.DefaultOnModel[num] = def
+			}
+		}
+	}
+	if dispatch := jsonSchemaEnum(node["x-ib-dispatch"]); dispatch != nil {
+		vtd.DispatchOptions = dispatch
+	}
+	if models := jsonSchemaInts(node["x-ib-only-on-model"]); models != nil {
+		vtd.OnlyOnModel = models
+	}
+	if models := jsonSchemaInts(node["x-ib-not-on-model"]); models != nil {
+		vtd.NotOnModel = models
+	}
+	if models := jsonSchemaModels(node["x-ib-models"]); models != nil {
+		vtd.Models = models
+	}
+	if hidden, ok := node["x-ib-hidden"].(string); ok {
+		vtd.Hidden = hidden
+	}
+	if secret, ok := node["x-ib-secret"].(bool); ok {
+		vtd.Secret = secret
+	}
+	if headline, ok := node["x-ib-headline"].(string); ok {
+		vtd.Headline = headline
+	}
+	if required, ok := node["x-ib-required"].(string); ok {
+		vtd.Required = required
+	}
+}
+
+func (imp *jsonSchemaImporter) properties(node map[string]interface{}, pointer string, refStack []string) (map[string]*cs.ValueTypeDescriptor, error) {
+	subtypes := make(map[string]*cs.ValueTypeDescriptor)
+	properties, _ := node["properties"].(map[string]interface{})
+	for name, property := range properties {
+		propertyNode, ok := property.(map[string]interface{})
+		if !ok {
+			imp.report(pointer+"/properties/"+name, "boolean schema")
+			continue
+		}
+		subtype, err := imp.node(propertyNode, pointer+"/properties/"+name, refStack)
+		if err != nil {
+			return nil, err
+		}
+		subtypes[name] = subtype
+	}
+
+	required, _ := node["required"].([]interface{})
+	for _, r := range required {
+		name, _ := r.(string)
+		subtype, ok := subtypes[name]
+		if !ok {
+			imp.report(pointer, "required ("+name+")")
+			continue
+		}
+		if subtype.Required == "" {
+			subtype.Required = defaultRequiredMessage
+		}
+	}
+	return subtypes, nil
+}
+
+func (imp *jsonSchemaImporter) reportRange(node map[string]interface{}, pointer string) {
+	for _, keyword := range []string{"minimum", "maximum"} {
+		if _, ok := node[keyword]; ok {
+			imp.report(pointer, keyword)
+		}
+	}
+}
+
+// jsonSchemaType returns the type of a node, a nullable type like ["string", "null"] is treated as the type itself
+func jsonSchemaType(node map[string]interface{}) (string, error) {
+	switch t := node["type"].(type) {
+	case string:
+		return t, nil
+	case []interface{}:
+		types := make([]string, 0)
+		for _, entry := range t {
+			if name, ok := entry.(string); ok && name != "null" {
+				types = append(types, name)
+			}
+		}
+		if len(types) != 1 {
+			return "", fmt.Errorf("multiple types %v can not be represented", types)
+		}
+		return types[0], nil
+	case nil:
+		if _, ok := node["properties"]; ok {
+			return "object", nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("invalid type %v", node["type"])
+}
+
+func jsonSchemaEnum(value interface{}) []string {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	options := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		switch v := entry.(type) {
+		case string:
+			options = append(options, v)
+		case float64:
+			options = append(options, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			options = append(options, fmt.Sprint(v))
+		}
+	}
+	return options
+}
+
+func jsonSchemaInts(value interface{}) []int {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	ints := make([]int, 0, len(entries))
+	for _, entry := range entries {
+		if num, ok := entry.(float64); ok {
+			ints = append(ints, int(num))
+		}
+	}
+	return ints
+}
+
+func jsonSchemaModels(value interface{}) []cs.Model {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	models := make([]cs.Model, 0, len(entries))
+	for _, entry := range entries {
+		object, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, ok := object["ID"].(float64)
+		if !ok {
+			continue
+		}
+		model := cs.Model{ID: int(id)}
+		model.Name, _ = object["Name"].(string)
+		model.Description, _ = object["Description"].(string)
+		model.Capabilities = jsonSchemaEnum(object["Capabilities"])
+		models = append(models, model)
+	}
+	return models
+}
+
+// jsonSchemaPresets reads the presets of a structure array, numbers of integer fields are converted to int
+func jsonSchemaPresets(value interface{}, fields map[string]*cs.ValueTypeDescriptor) []cs.Preset {
+	entries, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	presets := make([]cs.Preset, 0, len(entries))
+	for _, entry := range entries {
+		object, ok := entry.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		preset := cs.Preset{}
+		preset.Name, _ = object["Name"].(string)
+		preset.Description, _ = object["Description"].(string)
+		preset.Values, _ = object["Values"].(map[string]interface{})
+		for name, v := range preset.Values {
+			if num, ok := v.(float64); ok && fields[name] != nil && fields[name].Type != cs.ValueType_Float {
+				preset.Values[name] = int(num)
+			}
+		}
+		presets = append(presets, preset)
+	}
+	return presets
+}
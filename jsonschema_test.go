@@ -0,0 +1,129 @@
+package config_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+)
+
+type jsonSchemaDeviceConfig struct {
+	conf.BaseDeviceConfig
+	IP       string `ibValidate:"ip" ibDispatch:"deviceip" ibRequired:"Please enter the IP of the device"`
+	Port     uint16 `ibValidate:"port" ibDefault:"9910" ibDefaultOnModel:"7=52381"`
+	Password string `ibValidate:"password"`
+	Token    string `ibSecret:"true"`
+	Mode     string `ibOptions:"Auto,Manual" ibLabel:"Operating Mode" ibOnlyOnModel:"1,2"`
+}
+
+type jsonSchemaConfig struct {
+	PollMs  int `ibDescription:"Poll interval in milliseconds"`
+	Devices []jsonSchemaDeviceConfig
+}
+
+func TestExportJSONSchema(t *testing.T) {
+	data, err := conf.ExportJSONSchema(conf.GetSchema(&jsonSchemaConfig{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Schema     string `json:"$schema"`
+		Properties map[string]struct {
+			Type        string
+			Description string
+			Items       struct {
+				Required   []string
+				Properties map[string]map[string]interface{}
+			}
+		}
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	if doc.Schema != conf.JSONSchemaDraft {
+		t.Errorf("unexpected $schema %q", doc.Schema)
+	}
+	if doc.Properties["PollMs"].Type != "integer" || doc.Properties["PollMs"].Description == "" {
+		t.Errorf("unexpected PollMs %+v", doc.Properties["PollMs"])
+	}
+
+	devices := doc.Properties["Devices"]
+	if devices.Type != "array" || len(devices.Items.Required) != 1 || devices.Items.Required[0] != "IP" {
+		t.Errorf("unexpected Devices %+v", devices)
+	}
+	if port := devices.Items.Properties["Port"]; port["maximum"] != float64(65535) || port["default"] != float64(9910) {
+		t.Errorf("unexpected Port %v", port)
+	}
+	if mode := devices.Items.Properties["Mode"]; mode["title"] != "Operating Mode" || len(mode["enum"].([]interface{})) != 2 || mode["x-ib-only-on-model"] == nil {
+		t.Errorf("unexpected Mode %v", mode)
+	}
+	if password := devices.Items.Properties["Password"]; password["writeOnly"] != true {
+		t.Errorf("unexpected Password %v", password)
+	}
+	if token := devices.Items.Properties["Token"]; token["x-ib-secret"] != true || token["writeOnly"] != nil || token["type"] != "string" {
+		t.Errorf("unexpected Token %v", token)
+	}
+}
+
+func TestImportJSONSchema(t *testing.T) {
+	schema := conf.GetSchema(&jsonSchemaConfig{})
+	data, err := conf.ExportJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imported, unsupported, err := conf.ImportJSONSchema(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsupported) != 0 {
+		t.Errorf("unexpected unsupported keywords %v", unsupported)
+	}
+
+	expected, _ := json.Marshal(schema)
+	actual, _ := json.Marshal(imported)
+	if string(expected) != string(actual) {
+		t.Errorf("round trip mismatch\nexpected %s\nactual   %s", expected, actual)
+	}
+
+	partnerSchema := []byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"required": ["Host"],
+		"properties": {
+			"Host": {"type": "string", "format": "hostname"},
+			"Retries": {"type": "integer", "minimum": 1},
+			"Cameras": {"type": "array", "items": {"$ref": "#/$defs/Camera"}}
+		},
+		"$defs": {
+			"Camera": {
+				"type": "object",
+				"properties": {
+					"Name": {"type": "string", "pattern": "^[A-Z]"},
+					"Mode": {"type": "string", "enum": ["Auto", "Manual"]}
+				}
+			}
+		}
+	}`)
+	imported, unsupported, err = conf.ImportJSONSchema(partnerSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsupported) != 2 || unsupported[0] != "#/$defs/Camera/properties/Name: pattern" || unsupported[1] != "#/properties/Retries: minimum" {
+		t.Errorf("unexpected unsupported keywords %v", unsupported)
+	}
+
+	values := map[string]interface{}{
+		"Host":    "camera.local",
+		"Retries": float64(3),
+		"Cameras": []interface{}{map[string]interface{}{"Name": "Cam", "Mode": "Auto"}},
+	}
+	if _, err := conf.ValidateConfig(imported, values, true, "test"); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+	if imported.StructureSubtypes["Host"].Required == "" {
+		t.Errorf("expected Host to be required")
+	}
+}
@@ -0,0 +1,623 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	log "github.com/s00500/env_logger"
+)
+
+// Layer is the source a config value has been loaded from
+type Layer = string
+
+// Layers in order of precedence, later layers override earlier ones
+const (
+	Layer_Default Layer = "default" // The structure passed to Load and ibDefault tags
+	Layer_Vendor  Layer = "vendor"  // The file set with SetVendorFile
+	Layer_User    Layer = "user"    // The config file of the core, the only layer Save writes to
+	Layer_DropIn  Layer = "drop-in" // Files in the <core>.d directory, in order of their names
+	Layer_Env     Layer = "env"     // Environment variables with the prefix set with SetEnvPrefix
+	Layer_Flag    Layer = "flag"    // Overrides set with the flag added by AddFlags or with SetFlagOverride
+)
+
+// deviceIDKey identifies the elements of structure arrays across layers
+const deviceIDKey = "DeviceID"
+
+var vendorFile string = ""
+var envPrefix string = ""
+var flagOverrides []string
+
+// valueOrigin is where a leaf value has been loaded from
+type valueOrigin struct {
+	layer  Layer
+	source Source
+	file   string
+	line   int
+}
+
+// layerSource is a document merged into the config, with the lines of its values if its codec can tell them
+type layerSource struct {
+	layer Layer
+	file  string
+	lines map[string]int
+}
+
+// origin returns the origin of a value by its path in the source document, values without a line of their own (eg. in inline tables) get the line of their parent
+func (s *layerSource) origin(path string) valueOrigin {
+	origin := valueOrigin{layer: s.layer, source: layerSources[s.layer], file: s.file}
+	for path != "" && s.lines != nil {
+		if line, ok := s.lines[path]; ok {
+			origin.line = line
+			break
+		}
+		if i := strings.LastIndex(path, "."); i >= 0 {
+			path = path[:i]
+		} else {
+			path = ""
+		}
+	}
+	return origin
+}
+
+// layerState remembers where the values of the last Load came from, so Save only writes the user layer
+var layerState struct {
+	sync.Mutex
+	layered bool                   // Values have been loaded from other layers than the user file and the defaults
+	layers  map[string]valueOrigin // Origin of each leaf value by path (eg. "Devices.0.Port")
+	loaded  map[string]interface{} // Leaf values after the Load
+	user    map[string]interface{} // Content of the user file
+}
+
+// SetVendorFile sets a config file shipped with the core. Its values override the defaults and are overridden by the config file of the core.
+// The format is chosen by the extension of the file
+func SetVendorFile(file string) {
+	vendorFile = file
+}
+
+// SetEnvPrefix enables overriding config values with environment variables, eg. with the prefix "EXAMPLE" the variable EXAMPLE_DEVICES_0_PORT overrides Devices.0.Port.
+// Keys are matched case insensitively, elements of arrays are addressed by index
+func SetEnvPrefix(prefix string) {
+	envPrefix = prefix
+}
+
+// SetFlagOverride overrides a config value by path (eg. "Devices.0.Port") with the highest precedence
+func SetFlagOverride(path, value string) {
+	flagOverrides = append(flagOverrides, path+"="+value)
+}
+
+// ClearFlagOverrides removes all overrides set with SetFlagOverride or from the command line
+func ClearFlagOverrides() {
+	flagOverrides = nil
+}
+
+// AddFlags adds the repeatable flag -config-set Path=value to a FlagSet, to override config values from the command line
+func AddFlags(fs *flag.FlagSet) {
+	fs.Var(overrideFlag{}, "config-set", "override a config value, eg. -config-set Devices.0.Port=9910 (repeatable)")
+}
+
+type overrideFlag struct{}
+
+func (overrideFlag) String() string {
+	return strings.Join(flagOverrides, ",")
+}
+
+func (overrideFlag) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected Path=value")
+	}
+	flagOverrides = append(flagOverrides, value)
+	return nil
+}
+
+// GetLayer returns the layer a value of the last Load came from by its path (eg. "Devices.0.Port")
+func GetLayer(path string) Layer {
+	layerState.Lock()
+	defer layerState.Unlock()
+	if origin, ok := layerState.layers[path]; ok {
+		return origin.layer
+	}
+	return Layer_Default
+}
+
+// GetLayers returns the layers of all values of the last Load that did not come from the defaults
+func GetLayers() map[string]Layer {
+	layerState.Lock()
+	defer layerState.Unlock()
+	copied := make(map[string]Layer, len(layerState.layers))
+	for path, origin := range layerState.layers {
+		if origin.layer != Layer_Default { // Defaults filled in on Load only carry their source
+			copied[path] = origin.layer
+		}
+	}
+	return copied
+}
+
+// mergeLayers merges the user file with all other layers into one toml document, data is empty if there is no user file yet
+func mergeLayers(t reflect.Type, data []byte, format ConfigFormat) ([]byte, error) {
+	user := data
+	if len(data) > 0 {
+		var err error
+		if user, err = toTOML(format, data); err != nil {
+			return nil, err
+		}
+	}
+	userDoc, err := tomlCodec{}.Unmarshal(user)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make(map[string]valueOrigin)
+	merged := make(map[string]interface{})
+	layered := false
+
+	if vendorFile != "" {
+		vendorDoc, source, err := readLayer(vendorFile, Layer_Vendor)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("on reading vendor config: %w", err)
+		}
+		if err == nil {
+			mergeDocument(merged, vendorDoc, "", "", source, layers)
+			layered = true
+		}
+	}
+
+	userSource := &layerSource{layer: Layer_User, file: configFileName(coreName, format)}
+	userSource.lines = documentLines(format, data)
+	mergeDocument(merged, userDoc, "", "", userSource, layers)
+
+	dropIns, err := dropInFiles()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range dropIns {
+		doc, source, err := readLayer(file, Layer_DropIn)
+		if err != nil {
+			return nil, fmt.Errorf("on reading drop-in config: %w", err)
+		}
+		mergeDocument(merged, doc, "", "", source, layers)
+		layered = true
+	}
+
+	for _, override := range envOverrides(t) {
+		layered = applyOverride(t, merged, override.path, override.value, override.tokens, Layer_Env, layers) || layered
+	}
+	for _, override := range flagOverrides {
+		parts := strings.SplitN(override, "=", 2)
+		layered = applyOverride(t, merged, parts[0], parts[1], strings.Split(parts[0], "."), Layer_Flag, layers) || layered
+	}
+
+	layerState.Lock()
+	layerState.layered = layered
+	layerState.layers = layers
+	layerState.user = userDoc
+	layerState.Unlock()
+
+	if !layered {
+		return user, nil
+	}
+	return tomlCodec{}.Marshal(merged)
+}
+
+// storeLoadedValues remembers the values after Load, to find values of other layers the core did not change on Save
+func storeLoadedValues(structure interface{}) error {
+	data, err := encodeConfig(structure)
+	if err != nil {
+		return err
+	}
+	doc, err := tomlCodec{}.Unmarshal(data)
+	if err != nil {
+		return err
+	}
+	loaded := make(map[string]interface{})
+	flattenDocument(doc, "", loaded)
+
+	layerState.Lock()
+	layerState.loaded = loaded
+	layerState.Unlock()
+	return nil
+}
+
+// encodeUserLayer encodes the values of the user layer: all values except the ones loaded from other layers and not changed since.
+// Secret references are written back and password fields are encrypted if a key provider is set
+func encodeUserLayer(structure interface{}) ([]byte, error) {
+	data, err := encodeConfig(structure)
+	if err != nil {
+		return nil, err
+	}
+
+	layerState.Lock()
+	layered, user := layerState.layered, layerState.user
+	layerState.Unlock()
+
+	if layered {
+		doc, err := tomlCodec{}.Unmarshal(data)
+		if err != nil {
+			return nil, err
+		}
+		layerState.Lock()
+		values := userValues(doc, user, "")
+		layerState.Unlock()
+		if data, err = (tomlCodec{}).Marshal(values); err != nil {
+			return nil, err
+		}
+	}
+	schema := GetSchema(structure)
+	if data, err = restoreSecretReferences(data, schema); err != nil {
+		return nil, err
+	}
+	return encryptPasswords(data, schema)
+}
+
+func userValues(doc, user map[string]interface{}, path string) map[string]interface{} {
+	values := make(map[string]interface{})
+	for key, value := range doc {
+		p := joinPath(path, key)
+		userValue, inUser := user[documentKey(user, key)]
+
+		if table, ok := value.(map[string]interface{}); ok {
+			userTable, _ := userValue.(map[string]interface{})
+			if sub := userValues(table, userTable, p); len(sub) > 0 {
+				values[key] = sub
+			}
+			continue
+		}
+
+		if elements, ok := value.([]map[string]interface{}); ok && hasDeviceIDs(elements) {
+			userElements, _ := userValue.([]map[string]interface{})
+			kept := make([]map[string]interface{}, 0, len(elements))
+			for i, element := range elements {
+				userElement := findDevice(userElements, element[deviceIDKey])
+				sub := userValues(element, userElement, joinPath(p, strconv.Itoa(i)))
+				if len(sub) > 0 || userElement != nil {
+					sub[deviceIDKey] = element[deviceIDKey] // Needed to merge with the element of the other layer
+					kept = append(kept, sub)
+				}
+			}
+			if len(kept) > 0 {
+				values[key] = kept
+			}
+			continue
+		}
+
+		if inherited(value, p) {
+			if inUser {
+				values[key] = userValue // Keep the value that is overridden by a higher layer
+			}
+			continue
+		}
+		values[key] = value
+	}
+	return values
+}
+
+// inherited returns true if all leaves of a value have been loaded from another layer than the user file and are unchanged
+func inherited(value interface{}, path string) bool {
+	leaves := make(map[string]interface{})
+	flattenValue(value, path, leaves)
+	if len(leaves) == 0 {
+		return false
+	}
+	for p, leaf := range leaves {
+		origin, ok := layerState.layers[p]
+		if !ok || origin.layer == Layer_User || origin.layer == Layer_Default || !reflect.DeepEqual(layerState.loaded[p], leaf) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDocument deep merges src into dst and records the origin of every value taken from src, srcPath is the path of src in its own document
+func mergeDocument(dst, src map[string]interface{}, path, srcPath string, source *layerSource, layers map[string]valueOrigin) {
+	for key, value := range src {
+		srcKeyPath := joinPath(srcPath, key)
+		key = documentKey(dst, key)
+		p := joinPath(path, key)
+
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if existing, ok := dst[key].(map[string]interface{}); ok {
+				mergeDocument(existing, v, p, srcKeyPath, source, layers)
+				continue
+			}
+		case []map[string]interface{}:
+			if existing, ok := dst[key].([]map[string]interface{}); ok && hasDeviceIDs(existing) && hasDeviceIDs(v) {
+				dst[key] = mergeDevices(existing, v, p, srcKeyPath, source, layers)
+				continue
+			}
+		}
+
+		for existing := range layers {
+			if existing == p || strings.HasPrefix(existing, p+".") {
+				delete(layers, existing)
+			}
+		}
+		dst[key] = copyValue(value)
+
+		leaves := make(map[string]interface{})
+		flattenValue(value, p, leaves)
+		for leaf := range leaves {
+			layers[leaf] = source.origin(srcKeyPath + strings.TrimPrefix(leaf, p))
+		}
+	}
+}
+
+// mergeDevices merges the elements of two structure arrays by their DeviceID, new elements are appended
+func mergeDevices(dst, src []map[string]interface{}, path, srcPath string, source *layerSource, layers map[string]valueOrigin) []map[string]interface{} {
+	for i, element := range src {
+		index := -1
+		for j, existing := range dst {
+			if fmt.Sprint(existing[deviceIDKey]) == fmt.Sprint(element[deviceIDKey]) {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			index = len(dst)
+			dst = append(dst, make(map[string]interface{}))
+		}
+		mergeDocument(dst[index], element, joinPath(path, strconv.Itoa(index)), joinPath(srcPath, strconv.Itoa(i)), source, layers)
+	}
+	return dst
+}
+
+func hasDeviceIDs(elements []map[string]interface{}) bool {
+	for _, element := range elements {
+		if _, ok := element[deviceIDKey]; !ok {
+			return false
+		}
+	}
+	return len(elements) > 0
+}
+
+func findDevice(elements []map[string]interface{}, deviceID interface{}) map[string]interface{} {
+	for _, element := range elements {
+		if fmt.Sprint(element[deviceIDKey]) == fmt.Sprint(deviceID) {
+			return element
+		}
+	}
+	return nil
+}
+
+// documentKey returns the key used in a document for a key, falling back to a case insensitive match like the toml decoder does.
+// Keys missing in the document are returned unchanged, so doc[documentKey(doc, key)] looks up a value
+func documentKey(doc map[string]interface{}, key string) string {
+	if _, ok := doc[key]; ok {
+		return key
+	}
+	for k := range doc {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
+
+// flattenDocument collects all leaf values of a document by their path, plain arrays are leaves
+func flattenDocument(doc map[string]interface{}, path string, leaves map[string]interface{}) {
+	for key, value := range doc {
+		flattenValue(value, joinPath(path, key), leaves)
+	}
+}
+
+func flattenValue(value interface{}, path string, leaves map[string]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		flattenDocument(v, path, leaves)
+	case []map[string]interface{}:
+		for i, element := range v {
+			flattenDocument(element, joinPath(path, strconv.Itoa(i)), leaves)
+		}
+	default:
+		leaves[path] = value
+	}
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			copied[key] = copyValue(element)
+		}
+		return copied
+	case []map[string]interface{}:
+		copied := make([]map[string]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = copyValue(element).(map[string]interface{})
+		}
+		return copied
+	}
+	return value
+}
+
+// readLayer reads a config file of any registered format, chosen by its extension
+func readLayer(file string, layer Layer) (map[string]interface{}, *layerSource, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	format := strings.TrimPrefix(filepath.Ext(file), ".")
+	if GetCodec(format) == nil {
+		format = ConfigFormat_TOML
+	}
+	doc, err := DecodeDocument(format, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc, &layerSource{layer: layer, file: file, lines: documentLines(format, data)}, nil
+}
+
+// documentLines returns the lines of the values of a file if its codec can tell them
+func documentLines(format ConfigFormat, data []byte) map[string]int {
+	locator, ok := GetCodec(format).(LineLocator)
+	if !ok {
+		return nil
+	}
+	lines, err := locator.Lines(data)
+	if err != nil {
+		log.Debugf("on locating lines of %s config: %v", format, err)
+		return nil
+	}
+	return lines
+}
+
+// dropInFiles returns the files in the <core>.d directory in order of their names
+func dropInFiles() ([]string, error) {
+	entries, err := os.ReadDir(getBaseFileName(coreName) + ".d")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("on reading drop-in directory: %w", err)
+	}
+
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || GetCodec(strings.TrimPrefix(filepath.Ext(entry.Name()), ".")) == nil {
+			continue
+		}
+		files = append(files, filepath.Join(getBaseFileName(coreName)+".d", entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+type envOverride struct {
+	path   string
+	value  string
+	tokens []string
+}
+
+// envOverrides returns the environment variables with the env prefix, sorted by name
+func envOverrides(t reflect.Type) []envOverride {
+	if envPrefix == "" {
+		return nil
+	}
+	prefix := strings.ToUpper(envPrefix) + "_"
+
+	overrides := make([]envOverride, 0)
+	for _, variable := range os.Environ() {
+		parts := strings.SplitN(variable, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(strings.ToUpper(parts[0]), prefix) {
+			continue
+		}
+		overrides = append(overrides, envOverride{path: parts[0], value: parts[1], tokens: strings.Split(parts[0][len(prefix):], "_")})
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].path < overrides[j].path })
+	return overrides
+}
+
+// applyOverride sets a single value in the merged document, name is only used for warnings
+func applyOverride(t reflect.Type, doc map[string]interface{}, name, value string, tokens []string, layer Layer, layers map[string]valueOrigin) bool {
+	keys, leafType, ok := resolvePath(t, tokens, layer == Layer_Env)
+	if !ok {
+		log.Warnf("ignoring %s override %s: no such config value", layer, name)
+		return false
+	}
+
+	leaf := reflect.New(leafType).Elem()
+	if err := setFromString(leaf, value); err != nil {
+		log.Warnf("ignoring %s override %s: %v", layer, name, err)
+		return false
+	}
+
+	path, err := setDocumentValue(doc, keys, leaf.Interface(), "")
+	if err != nil {
+		log.Warnf("ignoring %s override %s: %v", layer, name, err)
+		return false
+	}
+	layers[path] = valueOrigin{layer: layer, source: layerSources[layer]}
+	return true
+}
+
+// resolvePath finds the keys of a value by the tokens of its path. With joinTokens keys can span multiple tokens, as environment variables use _ for both
+func resolvePath(t reflect.Type, tokens []string, joinTokens bool) ([]string, reflect.Type, bool) {
+	if len(tokens) == 0 {
+		return nil, t, true
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Slice && isStructValue(t.Elem()) {
+		if index, err := strconv.Atoi(tokens[0]); err != nil || index < 0 {
+			return nil, nil, false
+		}
+		keys, leafType, ok := resolvePath(t.Elem(), tokens[1:], joinTokens)
+		return append([]string{tokens[0]}, keys...), leafType, ok
+	}
+	if !isStructValue(t) {
+		return nil, nil, false
+	}
+
+	n := 1
+	if joinTokens {
+		n = len(tokens)
+	}
+	for i := 1; i <= n; i++ {
+		field, ok := fieldByKey(t, strings.Join(tokens[:i], "_"))
+		if !ok {
+			continue
+		}
+		if keys, leafType, ok := resolvePath(field.Type, tokens[i:], joinTokens); ok {
+			return append([]string{tomlKey(field)}, keys...), leafType, true
+		}
+	}
+	return nil, nil, false
+}
+
+// fieldByKey finds a field of a struct by its toml key, including the fields of embedded structs like BaseDeviceConfig
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if isFlattened(field) {
+			if embedded, ok := fieldByKey(field.Type, key); ok {
+				return embedded, true
+			}
+			continue
+		}
+		if name := tomlKey(field); name != "" && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// setDocumentValue sets a value by its keys and returns its path, elements of arrays must exist already
+func setDocumentValue(doc map[string]interface{}, keys []string, value interface{}, path string) (string, error) {
+	key := documentKey(doc, keys[0])
+	p := joinPath(path, key)
+	if len(keys) == 1 {
+		doc[key] = value
+		return p, nil
+	}
+
+	switch next := doc[key].(type) {
+	case nil:
+		if _, err := strconv.Atoi(keys[1]); err == nil {
+			return "", fmt.Errorf("%s has no element %s", p, keys[1])
+		}
+		table := make(map[string]interface{})
+		doc[key] = table
+		return setDocumentValue(table, keys[1:], value, p)
+	case map[string]interface{}:
+		return setDocumentValue(next, keys[1:], value, p)
+	case []map[string]interface{}:
+		index, _ := strconv.Atoi(keys[1])
+		if index >= len(next) {
+			return "", fmt.Errorf("%s has no element %d", p, index)
+		}
+		if len(keys) == 2 {
+			return "", fmt.Errorf("%s is no value", joinPath(p, keys[1]))
+		}
+		return setDocumentValue(next[index], keys[2:], value, joinPath(p, keys[1]))
+	}
+	return "", fmt.Errorf("%s is no table", p)
+}
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+	log "github.com/s00500/env_logger"
+)
+
+type itemLimits struct {
+	min int
+	max int
+}
+
+var itemLimitOverrides = make(map[string]itemLimits)
+var itemLimitOverridesMu sync.Mutex
+
+// SetItemLimits overrides the ibMinItems and ibMaxItems tags of the array at fieldPath (eg. "Devices"), eg. for limits that depend on a licence.
+// 0 means no limit. The limits are applied whenever the schema is generated, use UpdateSchema to rewrite the schema file when they change
+func SetItemLimits(fieldPath string, min, max int) error {
+	if err := validateItemLimits(min, max); err != nil {
+		return err
+	}
+	itemLimitOverridesMu.Lock()
+	defer itemLimitOverridesMu.Unlock()
+	itemLimitOverrides[fieldPath] = itemLimits{min: min, max: max}
+	return nil
+}
+
+// ClearItemLimits removes all overrides set with SetItemLimits, the tags apply again
+func ClearItemLimits() {
+	itemLimitOverridesMu.Lock()
+	defer itemLimitOverridesMu.Unlock()
+	itemLimitOverrides = make(map[string]itemLimits)
+}
+
+func validateItemLimits(min, max int) error {
+	if min < 0 || max < 0 {
+		return fmt.Errorf("item limits can not be negative, got %d and %d", min, max)
+	}
+	if max > 0 && min > max {
+		return fmt.Errorf("minimum of %d items is more than the maximum of %d", min, max)
+	}
+	return nil
+}
+
+func applyItemLimits(schema *cs.ValueTypeDescriptor) {
+	itemLimitOverridesMu.Lock()
+	defer itemLimitOverridesMu.Unlock()
+
+	for fieldPath, limits := range itemLimitOverrides {
+		vtd := descriptorAtPath(schema, fieldPath)
+		if vtd == nil || !hasItems(vtd) {
+			log.Warnf("item limits set for %s which is no array", fieldPath)
+			continue
+		}
+		vtd.MinItems, vtd.MaxItems = limits.min, limits.max
+	}
+}
+
+func hasItems(vtd *cs.ValueTypeDescriptor) bool {
+	return vtd.Type == cs.ValueType_Array || vtd.Type == cs.ValueType_StructureArray || vtd.Type == cs.ValueType_MultiSelect
+}
+
+// itemCount returns the number of elements of an array value, nil counts as empty. Values that are no array are left to the type checks
+func itemCount(values interface{}) (int, bool) {
+	if values == nil {
+		return 0, true
+	}
+	if v := reflect.ValueOf(values); v.Kind() == reflect.Slice {
+		return v.Len(), true
+	}
+	return 0, false
+}
+
+// validateItemCount checks the number of elements of an array against the limits of its schema
+func validateItemCount(schema *cs.ValueTypeDescriptor, count int) error {
+	if schema.MinItems > 0 && count < schema.MinItems {
+		return fmt.Errorf("array has %d items, at least %d required", count, schema.MinItems)
+	}
+	if schema.MaxItems > 0 && count > schema.MaxItems {
+		return fmt.Errorf("array has %d items, at most %d allowed", count, schema.MaxItems)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	log "github.com/s00500/env_logger"
+)
+
+// LoadReport describes what LoadWithReport changed or found while loading a config
+type LoadReport struct {
+	FilledKeys  []string        // Keys missing in the config file that have been filled with defaults (eg. "Global.PollMs", "Devices.0.Port")
+	UnknownKeys []string        // Keys in the config file that do not exist in the structure (eg. a typo like "Devices.0.IpAdress")
+	Integrity   IntegrityStatus // Result of the integrity check of the config file, empty if SetIntegrityCheck is not enabled
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// tomlKey returns the key of a field in the toml file, or "" if the field is not stored
+func tomlKey(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name := strings.Split(field.Tag.Get("toml"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// isFlattened returns true for embedded structs like BaseDeviceConfig, whose fields are stored in the parent
+func isFlattened(field reflect.StructField) bool {
+	return field.Anonymous && field.Type.Kind() == reflect.Struct && field.Tag.Get("toml") == ""
+}
+
+// isStructValue returns true for structs that are stored as tables in the toml file
+func isStructValue(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// fillDefaults sets all fields missing in the raw toml data to the value of the default structure.
+// Slices are only filled if there is no user file yet, the encoder leaves out nil slices so a missing slice can be one the core emptied
+func fillDefaults(target, defaults reflect.Value, raw map[string]interface{}, path string, userFile bool, report *LoadReport) {
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		if isFlattened(field) {
+			fillDefaults(target.Field(i), defaults.Field(i), raw, path, userFile, report)
+			continue
+		}
+		key := tomlKey(field)
+		if key == "" {
+			continue
+		}
+
+		rawValue, present := raw[documentKey(raw, key)]
+		if !present {
+			if userFile && field.Type.Kind() == reflect.Slice {
+				continue
+			}
+			if !defaults.Field(i).IsZero() { // The encoder leaves out nil values, nothing to fill for them
+				target.Field(i).Set(defaults.Field(i))
+				report.FilledKeys = append(report.FilledKeys, joinPath(path, key))
+			}
+			continue
+		}
+
+		if rawMap, ok := rawValue.(map[string]interface{}); ok && isStructValue(field.Type) {
+			fillDefaults(target.Field(i), defaults.Field(i), rawMap, joinPath(path, key), userFile, report)
+			continue
+		}
+		fillElementsDefaults(target.Field(i), rawValue, joinPath(path, key), report)
+	}
+}
+
+// fillElementsDefaults fills the fields missing in the elements of a structure array with their ibDefault tags
+func fillElementsDefaults(target reflect.Value, rawValue interface{}, path string, report *LoadReport) {
+	if target.Kind() != reflect.Slice {
+		return
+	}
+	rawElements := rawArray(rawValue)
+	for j := 0; j < target.Len() && j < len(rawElements); j++ {
+		element := target.Index(j)
+		if element.Kind() == reflect.Ptr {
+			if element.IsNil() {
+				continue
+			}
+			element = element.Elem()
+		}
+		rawElement, ok := rawElements[j].(map[string]interface{})
+		if !ok || !isStructValue(element.Type()) {
+			continue
+		}
+		model, hasModel := modelOf(element)
+		fillTagDefaults(element, rawElement, joinPath(path, strconv.Itoa(j)), model, hasModel, report)
+	}
+}
+
+// fillTagDefaults fills the fields missing in an element with their ibDefaultOnModel tag for the model of the element or their ibDefault tag
+func fillTagDefaults(target reflect.Value, raw map[string]interface{}, path string, model int, hasModel bool, report *LoadReport) {
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Type().Field(i)
+		if isFlattened(field) {
+			fillTagDefaults(target.Field(i), raw, path, model, hasModel, report)
+			continue
+		}
+		key := tomlKey(field)
+		if key == "" {
+			continue
+		}
+
+		rawValue, present := raw[documentKey(raw, key)]
+		if present {
+			if rawMap, ok := rawValue.(map[string]interface{}); ok && isStructValue(field.Type) {
+				fillTagDefaults(target.Field(i), rawMap, joinPath(path, key), model, hasModel, report)
+			} else {
+				fillElementsDefaults(target.Field(i), rawValue, joinPath(path, key), report)
+			}
+			continue
+		}
+
+		defaultTag, ok := field.Tag.Lookup("ibDefault")
+		if modelTag := field.Tag.Get("ibDefaultOnModel"); modelTag != "" && hasModel {
+			if modelDefaults, err := parseDefaultOnModel(modelTag); err == nil {
+				if modelDefault, found := modelDefaults[model]; found {
+					defaultTag, ok = modelDefault, true
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		if err := setFromString(target.Field(i), defaultTag); err != nil {
+			log.Warnf("on applying default of %s: %v", joinPath(path, key), err)
+			continue
+		}
+		report.FilledKeys = append(report.FilledKeys, joinPath(path, key))
+	}
+}
+
+// modelOf returns the model id of a structure array element, read from its field tagged ibDispatch:"modelid"
+func modelOf(element reflect.Value) (int, bool) {
+	value, ok := dispatchValue(element, Dispatch_ModelID)
+	if !ok {
+		return 0, false
+	}
+	return intValue(value)
+}
+
+// intValue returns the value of an integer field
+func intValue(value reflect.Value) (int, bool) {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(value.Uint()), true
+	}
+	return 0, false
+}
+
+// dispatchValue returns the field of a structure array element with a dispatch role, including the fields of embedded structs like BaseDeviceConfig
+func dispatchValue(element reflect.Value, role string) (reflect.Value, bool) {
+	for i := 0; i < element.NumField(); i++ {
+		field := element.Type().Field(i)
+		if isFlattened(field) {
+			if value, ok := dispatchValue(element.Field(i), role); ok {
+				return value, true
+			}
+			continue
+		}
+		if field.IsExported() && containsString(strings.Split(field.Tag.Get("ibDispatch"), ","), role) {
+			return element.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// rawArray returns the elements of a decoded toml array, arrays of tables are decoded as []map[string]interface{}
+func rawArray(rawValue interface{}) []interface{} {
+	switch v := rawValue.(type) {
+	case []interface{}:
+		return v
+	case []map[string]interface{}:
+		elements := make([]interface{}, len(v))
+		for i, element := range v {
+			elements[i] = element
+		}
+		return elements
+	}
+	return nil
+}
+
+// setFromString sets a field from the text of a struct tag like ibDefault
+func setFromString(target reflect.Value, value string) error {
+	switch target.Kind() {
+	case reflect.String:
+		target.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		target.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		num, err := strconv.ParseInt(value, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetInt(num)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		num, err := strconv.ParseUint(value, 10, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetUint(num)
+	case reflect.Float32, reflect.Float64:
+		num, err := strconv.ParseFloat(value, target.Type().Bits())
+		if err != nil {
+			return err
+		}
+		target.SetFloat(num)
+	case reflect.Slice:
+		if target.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported type %s", target.Type())
+		}
+		target.Set(reflect.ValueOf(strings.Split(value, ",")).Convert(target.Type()))
+	default:
+		return fmt.Errorf("unsupported type %s", target.Type())
+	}
+	return nil
+}
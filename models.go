@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// Model is an entry of the model catalog set with SetModels
+type Model = cs.Model
+
+// capabilityPrefix marks capabilities in ibOnlyOnModel and ibNotOnModel tags, eg. ibOnlyOnModel:"cap:ptz,12"
+const capabilityPrefix = "cap:"
+
+var models []Model
+var modelsMu sync.Mutex
+
+// SetModels sets the model catalog of the core. It is embedded in the schema on the field tagged ibDispatch:"modelid",
+// ValidateConfig rejects model ids that are not in the catalog. Call it before Load, nil removes the catalog
+func SetModels(catalog []Model) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	models = append([]Model(nil), catalog...)
+}
+
+// GetModel returns the entry of a model in the catalog
+func GetModel(id int) (Model, bool) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	for _, model := range models {
+		if model.ID == id {
+			return model, true
+		}
+	}
+	return Model{}, false
+}
+
+// ModelsWithCapability returns the ids of all models in the catalog with a capability
+func ModelsWithCapability(capability string) []int {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	return modelsWithCapability(capability)
+}
+
+func modelsWithCapability(capability string) []int {
+	ids := make([]int, 0)
+	for _, model := range models {
+		if containsString(model.Capabilities, capability) {
+			ids = append(ids, model.ID)
+		}
+	}
+	return ids
+}
+
+// parseModelTag parses an ibOnlyOnModel or ibNotOnModel tag, capabilities (eg. "cap:ptz") are resolved to the ids of the models in the catalog
+func parseModelTag(tag string) ([]int, error) {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+
+	ids := make([]int, 0)
+	for _, entry := range strings.Split(tag, ",") {
+		if strings.HasPrefix(entry, capabilityPrefix) {
+			capability := strings.TrimPrefix(entry, capabilityPrefix)
+			withCapability := modelsWithCapability(capability)
+			if len(withCapability) == 0 {
+				return nil, fmt.Errorf("no model in the catalog has the capability %q", capability)
+			}
+			for _, id := range withCapability {
+				if !containsInt(ids, id) {
+					ids = append(ids, id)
+				}
+			}
+			continue
+		}
+		num, err := strconv.ParseInt(entry, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if !containsInt(ids, int(num)) {
+			ids = append(ids, int(num))
+		}
+	}
+	return ids, nil
+}
+
+// applyModels embeds the model catalog in the descriptors of all fields tagged ibDispatch:"modelid"
+func applyModels(schema *cs.ValueTypeDescriptor) {
+	modelsMu.Lock()
+	catalog := models
+	modelsMu.Unlock()
+	if len(catalog) > 0 {
+		embedModels(schema, catalog)
+	}
+}
+
+func embedModels(schema *cs.ValueTypeDescriptor, catalog []Model) {
+	if schema == nil {
+		return
+	}
+	for _, sub := range schema.StructureSubtypes {
+		if sub == nil {
+			continue
+		}
+		if containsString(sub.DispatchOptions, Dispatch_ModelID) {
+			sub.Models = append([]Model(nil), catalog...)
+		}
+		if sub.Type == cs.ValueType_Structure || sub.Type == cs.ValueType_StructureArray {
+			embedModels(sub, catalog)
+		}
+	}
+}
+
+// validateModel returns an error for model ids that are not in the catalog of a schema, 0 is accepted for devices without a model
+func validateModel(schema *cs.ValueTypeDescriptor, id int) error {
+	if len(schema.Models) == 0 || id == 0 {
+		return nil
+	}
+	for _, model := range schema.Models {
+		if model.ID == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown model id %d", id)
+}
+
+func containsInt(all []int, one int) bool {
+	for _, a := range all {
+		if a == one {
+			return true
+		}
+	}
+	return false
+}
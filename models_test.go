@@ -0,0 +1,87 @@
+package config_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+)
+
+func TestModels(t *testing.T) {
+	conf.SetModels([]conf.Model{
+		{ID: 3, Name: "Switcher"},
+		{ID: 7, Name: "PTZ Camera", Description: "Pan tilt zoom camera", Capabilities: []string{"ptz", "tally"}},
+		{ID: 9, Name: "Studio Camera", Capabilities: []string{"tally"}},
+	})
+	defer conf.SetModels(nil)
+
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		Preset int  `ibOnlyOnModel:"cap:ptz"`
+		Tally  bool `ibOnlyOnModel:"cap:tally,3"`
+		Zoom   bool `ibNotOnModel:"cap:ptz"`
+	}
+	type Config struct {
+		Devices []DeviceConfig
+	}
+
+	schema := conf.GetSchema(&Config{})
+	devices := schema.StructureSubtypes["Devices"]
+	if models := devices.StructureSubtypes["ModelID"].Models; len(models) != 3 || models[1].Name != "PTZ Camera" {
+		t.Errorf("expected model catalog on ModelID, got %v", models)
+	}
+	if only := devices.StructureSubtypes["Tally"].OnlyOnModel; !reflect.DeepEqual(only, []int{7, 9, 3}) {
+		t.Errorf("expected capability to resolve to model ids, got %v", only)
+	}
+	if only, not := devices.StructureSubtypes["Preset"].OnlyOnModel, devices.StructureSubtypes["Zoom"].NotOnModel; !reflect.DeepEqual(only, []int{7}) || !reflect.DeepEqual(not, []int{7}) {
+		t.Errorf("expected ptz models, got %v and %v", only, not)
+	}
+	if model, ok := conf.GetModel(9); !ok || model.Name != "Studio Camera" {
+		t.Errorf("expected model 9 in catalog, got %v", model)
+	}
+
+	valid := map[string]interface{}{"Devices": []interface{}{
+		map[string]interface{}{"DeviceID": float64(1), "ModelID": float64(7)},
+		map[string]interface{}{"DeviceID": float64(2), "ModelID": float64(0)},
+	}}
+	if _, err := conf.ValidateConfig(schema, valid, true, "test"); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+	invalid := map[string]interface{}{"Devices": []interface{}{
+		map[string]interface{}{"DeviceID": float64(1), "ModelID": float64(4)},
+	}}
+	if _, err := conf.ValidateConfig(schema, invalid, true, "test"); err == nil {
+		t.Errorf("expected error for unknown model")
+	}
+	if _, errs := conf.ValidateConfigAll(schema, invalid, true, "test"); len(errs) != 1 || errs[0].Path != "Devices.0.ModelID" {
+		t.Errorf("expected unknown model at Devices.0.ModelID, got %v", errs)
+	}
+
+	data, err := conf.ExportJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, unsupported, err := conf.ImportJSONSchema(data)
+	if err != nil || len(unsupported) != 0 {
+		t.Fatalf("on importing schema: %v %v", err, unsupported)
+	}
+	expected, _ := json.Marshal(schema)
+	actual, _ := json.Marshal(imported)
+	if string(expected) != string(actual) {
+		t.Errorf("round trip mismatch\nexpected %s\nactual   %s", expected, actual)
+	}
+
+	conf.SetModels([]conf.Model{{ID: 3, Name: "Switcher"}, {ID: 7, Name: "PTZ Camera", Capabilities: []string{"ptz", "tally"}}, {ID: 12, Name: "Panel", Capabilities: []string{"tally"}}})
+	changes := conf.CompareSchemas(schema, conf.GetSchema(&Config{}))
+	kinds := make(map[string]bool)
+	for _, change := range changes {
+		kinds[change.Path+" "+change.Kind] = change.Breaking
+	}
+	if breaking, ok := kinds["Devices.ModelID model removed"]; !ok || !breaking {
+		t.Errorf("expected breaking removal of model 9, got %v", changes)
+	}
+	if breaking, ok := kinds["Devices.ModelID model added"]; !ok || breaking {
+		t.Errorf("expected compatible addition of model 12, got %v", changes)
+	}
+}
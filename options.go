@@ -0,0 +1,126 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+	log "github.com/s00500/env_logger"
+)
+
+// OptionsProvider returns the currently available options for a select field
+type OptionsProvider func() []string
+
+var optionsProviders = make(map[string]OptionsProvider)
+var optionsProvidersMu sync.Mutex
+
+// optionsCache holds the options returned by the providers until the next refresh, Load generates the schema several times
+var optionsCache = make(map[string][]string)
+
+// RegisterOptionsProvider registers a function that provides the options for the field at fieldPath (eg. "Devices.Input").
+// The options are read once per Load and Save and kept for the schemas generated in between, use UpdateSchema to read them again
+// and rewrite the schema file when they change. String fields become a select, integer fields an integer select and string arrays a multi select
+func RegisterOptionsProvider(fieldPath string, provider OptionsProvider) {
+	optionsProvidersMu.Lock()
+	defer optionsProvidersMu.Unlock()
+	optionsProviders[fieldPath] = provider
+	delete(optionsCache, fieldPath)
+}
+
+// RefreshOptions drops the cached options, the providers are called again the next time the schema is generated
+func RefreshOptions() {
+	optionsProvidersMu.Lock()
+	defer optionsProvidersMu.Unlock()
+	optionsCache = make(map[string][]string)
+}
+
+// UpdateSchema regenerates the schema of a core with fresh options and rewrites <core>.schema.json, call this when the data of an options provider changes
+func UpdateSchema(structure interface{}) error {
+	if coreName == "" {
+		log.Panic("no corename set")
+	}
+	RefreshOptions()
+	return storeSchema(getBaseFileName(coreName)+".schema.json", structure)
+}
+
+// applyOptionsProviders sets the options of all fields with a provider, from the cache if they have been read since the last refresh.
+// The providers are called without holding the lock, so they can use the config package themselves
+func applyOptionsProviders(schema *cs.ValueTypeDescriptor) {
+	optionsProvidersMu.Lock()
+	providers := make(map[string]OptionsProvider, len(optionsProviders))
+	for fieldPath, provider := range optionsProviders {
+		providers[fieldPath] = provider
+	}
+	cached := make(map[string][]string, len(optionsCache))
+	for fieldPath, options := range optionsCache {
+		cached[fieldPath] = options
+	}
+	optionsProvidersMu.Unlock()
+
+	for fieldPath, provider := range providers {
+		vtd := descriptorAtPath(schema, fieldPath)
+		if vtd == nil {
+			log.Warnf("options provider registered for unknown field %s", fieldPath)
+			continue
+		}
+
+		switch vtd.Type {
+		case cs.ValueType_String, cs.ValueType_Select:
+			vtd.Type = cs.ValueType_Select
+		case cs.ValueType_Integer, cs.ValueType_IntegerSelect:
+			vtd.Type = cs.ValueType_IntegerSelect
+		case cs.ValueType_MultiSelect:
+		case cs.ValueType_Array:
+			if vtd.ArraySubType == nil || vtd.ArraySubType.Type != cs.ValueType_String {
+				log.Warnf("options provider registered for non string array field %s", fieldPath)
+				continue
+			}
+			vtd.Type = cs.ValueType_MultiSelect
+			vtd.ArraySubType = nil
+		default:
+			log.Warnf("options provider registered for field %s which can not be a select", fieldPath)
+			continue
+		}
+
+		options, ok := cached[fieldPath]
+		if !ok {
+			options = provider()
+			optionsProvidersMu.Lock()
+			optionsCache[fieldPath] = options
+			optionsProvidersMu.Unlock()
+		}
+		if vtd.Type == cs.ValueType_IntegerSelect {
+			options = integerOptions(fieldPath, options)
+		}
+		vtd.Options = append([]string(nil), options...)
+	}
+}
+
+// integerOptions drops the options of an integer select that are no integers, they could never be stored in the field
+func integerOptions(fieldPath string, options []string) []string {
+	valid := make([]string, 0, len(options))
+	for _, option := range options {
+		if _, err := strconv.Atoi(option); err != nil {
+			log.Warnf("options provider of %s returned %q which is no integer, option is left out", fieldPath, option)
+			continue
+		}
+		valid = append(valid, option)
+	}
+	return valid
+}
+
+// descriptorAtPath returns the descriptor for a dotted field path, elements of arrays are addressed without index (eg. "Devices.Port")
+func descriptorAtPath(schema *cs.ValueTypeDescriptor, fieldPath string) *cs.ValueTypeDescriptor {
+	vtd := schema
+	for _, name := range strings.Split(fieldPath, ".") {
+		for vtd != nil && vtd.Type == cs.ValueType_Array {
+			vtd = vtd.ArraySubType
+		}
+		if vtd == nil || vtd.StructureSubtypes == nil {
+			return nil
+		}
+		vtd = vtd.StructureSubtypes[name]
+	}
+	return vtd
+}
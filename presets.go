@@ -0,0 +1,167 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+	log "github.com/s00500/env_logger"
+)
+
+// Preset is a named partial element of a structure array, registered with RegisterPreset
+type Preset = cs.Preset
+
+var presets = make(map[string][]Preset)
+var presetsMu sync.Mutex
+
+// RegisterPreset registers a preset for the structure array at fieldPath (eg. "Devices"). Presets are exported in the schema,
+// so the UI can add elements from them, and can be added to a config with AddFromPreset. A preset with the same name replaces the old one
+func RegisterPreset(fieldPath string, preset Preset) {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	for i, existing := range presets[fieldPath] {
+		if existing.Name == preset.Name {
+			presets[fieldPath][i] = preset
+			return
+		}
+	}
+	presets[fieldPath] = append(presets[fieldPath], preset)
+}
+
+// AddFromPreset appends a new element created from a preset to the structure array at fieldPath of a config structure.
+// Fields missing in the preset get their ibDefaultOnModel or ibDefault value, the element gets a fresh DeviceID which is returned
+func AddFromPreset(structure interface{}, fieldPath, name string) (int, error) {
+	preset, ok := findPreset(fieldPath, name)
+	if !ok {
+		return 0, fmt.Errorf("preset %q for %s is not registered", name, fieldPath)
+	}
+
+	v := reflect.ValueOf(structure)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return 0, fmt.Errorf("%T is no pointer to a config structure", structure)
+	}
+	array := v.Elem()
+	for _, fieldName := range strings.Split(fieldPath, ".") {
+		for array.Kind() == reflect.Ptr {
+			array = array.Elem()
+		}
+		if array.Kind() != reflect.Struct {
+			return 0, fmt.Errorf("%s is no structure array", fieldPath)
+		}
+		if array = array.FieldByName(fieldName); !array.IsValid() {
+			return 0, fmt.Errorf("%s does not exist in config structure", fieldPath)
+		}
+	}
+	elementType := array.Type()
+	if elementType.Kind() == reflect.Slice {
+		elementType = elementType.Elem()
+	}
+	isPtr := elementType.Kind() == reflect.Ptr
+	if isPtr {
+		elementType = elementType.Elem()
+	}
+	if array.Kind() != reflect.Slice || !isStructValue(elementType) {
+		return 0, fmt.Errorf("%s is no structure array", fieldPath)
+	}
+
+	element := reflect.New(elementType)
+	values := presetDocument(elementType, preset.Values)
+	data, err := (tomlCodec{}).Marshal(values)
+	if err != nil {
+		return 0, fmt.Errorf("on encoding preset %q: %w", name, err)
+	}
+	if _, err := toml.Decode(string(data), element.Interface()); err != nil {
+		return 0, fmt.Errorf("on applying preset %q: %w", name, err)
+	}
+	model, hasModel := modelOf(element.Elem())
+	fillTagDefaults(element.Elem(), values, fieldPath, model, hasModel, &LoadReport{})
+
+	id := 0
+	if idField, ok := dispatchValue(element.Elem(), Dispatch_DeviceID); ok {
+		for i := 0; i < array.Len(); i++ {
+			existing := array.Index(i)
+			if existing.Kind() == reflect.Ptr {
+				if existing.IsNil() {
+					continue
+				}
+				existing = existing.Elem()
+			}
+			if existingField, ok := dispatchValue(existing, Dispatch_DeviceID); ok {
+				if existingID, ok := intValue(existingField); ok && existingID > id {
+					id = existingID
+				}
+			}
+		}
+		id++
+		if err := setFromString(idField, fmt.Sprint(id)); err != nil {
+			return 0, fmt.Errorf("on setting device id: %w", err)
+		}
+	}
+
+	if isPtr {
+		array.Set(reflect.Append(array, element))
+	} else {
+		array.Set(reflect.Append(array, element.Elem()))
+	}
+	return id, nil
+}
+
+// presetDocument renames the keys of preset values from field names, like in the schema, to the toml keys of the element type
+func presetDocument(t reflect.Type, values map[string]interface{}) map[string]interface{} {
+	doc := make(map[string]interface{}, len(values))
+	for name, value := range values {
+		field, ok := t.FieldByName(name)
+		if !ok || tomlKey(field) == "" {
+			doc[name] = value // Unknown fields are warned about by applyPresets
+			continue
+		}
+		if nested, isMap := value.(map[string]interface{}); isMap && isStructValue(field.Type) {
+			value = presetDocument(field.Type, nested)
+		}
+		doc[tomlKey(field)] = value
+	}
+	return doc
+}
+
+// ClearPresets removes all registered presets
+func ClearPresets() {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	presets = make(map[string][]Preset)
+}
+
+func findPreset(fieldPath, name string) (Preset, bool) {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+	for _, preset := range presets[fieldPath] {
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return Preset{}, false
+}
+
+// applyPresets embeds the registered presets in the descriptors of their structure arrays
+func applyPresets(schema *cs.ValueTypeDescriptor) {
+	presetsMu.Lock()
+	defer presetsMu.Unlock()
+
+	for fieldPath, registered := range presets {
+		vtd := descriptorAtPath(schema, fieldPath)
+		if vtd == nil || vtd.Type != cs.ValueType_StructureArray {
+			log.Warnf("presets registered for %s which is no structure array", fieldPath)
+			continue
+		}
+		for _, preset := range registered {
+			for name := range preset.Values {
+				if _, ok := vtd.StructureSubtypes[name]; !ok {
+					log.Warnf("preset %q for %s sets %s which does not exist in schema", preset.Name, fieldPath, name)
+				}
+			}
+		}
+		vtd.Presets = append([]Preset(nil), registered...)
+	}
+}
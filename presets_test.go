@@ -0,0 +1,87 @@
+package config_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+)
+
+func TestPresets(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP    string `ibValidate:"ip"`
+		Port  uint16 `ibDefault:"80" ibDefaultOnModel:"3=9910"`
+		Tally bool
+		Mode  string `ibOptions:"Auto,Manual" ibDefault:"Auto"`
+	}
+	type Config struct {
+		Devices []DeviceConfig
+	}
+
+	conf.RegisterPreset("Devices", conf.Preset{Name: "ATEM", Description: "ATEM on default port with tally", Values: map[string]interface{}{"ModelID": 3, "Tally": true}})
+	conf.RegisterPreset("Devices", conf.Preset{Name: "Manual", Values: map[string]interface{}{"Mode": "Manual", "Port": 1234, "DeviceID": 99}})
+	defer conf.ClearPresets()
+
+	schema := conf.GetSchema(&Config{})
+	presets := schema.StructureSubtypes["Devices"].Presets
+	if len(presets) != 2 || presets[0].Name != "ATEM" || presets[0].Values["Tally"] != true {
+		t.Errorf("expected presets in schema, got %v", presets)
+	}
+
+	config := Config{Devices: []DeviceConfig{{BaseDeviceConfig: conf.BaseDeviceConfig{DeviceID: 4}}}}
+	id, err := conf.AddFromPreset(&config, "Devices", "ATEM")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 5 || len(config.Devices) != 2 {
+		t.Fatalf("expected device 5 to be added, got %d %+v", id, config.Devices)
+	}
+	if device := config.Devices[1]; device.DeviceID != 5 || device.ModelID != 3 || !device.Tally || device.Port != 9910 || device.Mode != "Auto" {
+		t.Errorf("expected device from preset with model defaults, got %+v", device)
+	}
+
+	if id, err = conf.AddFromPreset(&config, "Devices", "Manual"); err != nil {
+		t.Fatal(err)
+	}
+	if device := config.Devices[2]; id != 6 || device.DeviceID != 6 || device.Port != 1234 || device.Mode != "Manual" {
+		t.Errorf("expected device 6 with preset values and fresh id, got %+v", device)
+	}
+	if _, err := conf.AddFromPreset(&config, "Devices", "Unknown"); err == nil {
+		t.Errorf("expected error for unknown preset")
+	}
+
+	type RenamedDevice struct { // Presets use the field names of the schema, not the toml keys
+		conf.BaseDeviceConfig
+		Address string `toml:"ip_address" ibDefault:"192.168.10.240"`
+		Port    uint16 `toml:"tcp_port" ibDefault:"80"`
+	}
+	type RenamedConfig struct {
+		Devices []RenamedDevice
+	}
+	conf.RegisterPreset("Devices", conf.Preset{Name: "Renamed", Values: map[string]interface{}{"Address": "10.0.0.1", "Port": 1234}})
+	var renamed RenamedConfig
+	if _, err := conf.AddFromPreset(&renamed, "Devices", "Renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if device := renamed.Devices[0]; device.Address != "10.0.0.1" || device.Port != 1234 {
+		t.Errorf("expected preset values for fields with toml keys, got %+v", device)
+	}
+
+	data, err := conf.ExportJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, unsupported, err := conf.ImportJSONSchema(data)
+	if err != nil || len(unsupported) != 0 {
+		t.Fatalf("on importing schema: %v %v", err, unsupported)
+	}
+	expected, _ := json.Marshal(schema)
+	actual, _ := json.Marshal(imported)
+	if string(expected) != string(actual) {
+		t.Errorf("round trip mismatch\nexpected %s\nactual   %s", expected, actual)
+	}
+	if imported.StructureSubtypes["Devices"].Presets[1].Values["Port"] != 1234 {
+		t.Errorf("expected integer preset values to be imported as int")
+	}
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// Source describes how a config value got its value
+type Source = string
+
+// Sources reported by GetProvenance
+const (
+	Source_Default      Source = "default"       // The structure passed to Load or an ibDefault tag
+	Source_File         Source = "file"          // The vendor file, the config file of the core or a drop-in file
+	Source_Migrated     Source = "migrated"      // Converted to another format or encrypted on Save, or set by the core with SetProvenance when migrating an old config
+	Source_AutoAssigned Source = "auto-assigned" // Filled in on Load because it was missing in the config file, or set by the core with SetProvenance, eg. a free DeviceID
+	Source_Env          Source = "env"
+	Source_Flag         Source = "flag"
+)
+
+var layerSources = map[Layer]Source{
+	Layer_Default: Source_Default,
+	Layer_Vendor:  Source_File,
+	Layer_User:    Source_File,
+	Layer_DropIn:  Source_File,
+	Layer_Env:     Source_Env,
+	Layer_Flag:    Source_Flag,
+}
+
+// Provenance is where a leaf value of the config came from
+type Provenance struct {
+	Path   string // Path of the value, elements of arrays are addressed by index (eg. "Devices.0.Port")
+	Value  interface{}
+	Source Source
+	Layer  Layer
+	File   string // File the value has been loaded from, only for Source_File
+	Line   int    // Line of the value in File, 0 if unknown
+}
+
+// SetProvenance records the source of a value the core changed after Load, eg. Source_Migrated or Source_AutoAssigned.
+// The value belongs to the config file of the core from then on
+func SetProvenance(path string, source Source) {
+	layerState.Lock()
+	defer layerState.Unlock()
+	if layerState.layers == nil {
+		layerState.layers = make(map[string]valueOrigin)
+	}
+	layerState.layers[path] = valueOrigin{layer: Layer_User, source: source}
+}
+
+// recordFilled records the values filled in with defaults on Load as auto-assigned, a filled structure covers all values inside of it
+func recordFilled(paths []string) {
+	layerState.Lock()
+	defer layerState.Unlock()
+	if layerState.layers == nil {
+		layerState.layers = make(map[string]valueOrigin)
+	}
+	for leaf := range layerState.loaded {
+		for _, path := range paths {
+			if leaf == path || strings.HasPrefix(leaf, path+".") {
+				layerState.layers[leaf] = valueOrigin{layer: Layer_Default, source: Source_AutoAssigned}
+			}
+		}
+	}
+}
+
+// recordMigrated records a value loaded from the config file that is saved in a new form, eg. a plain text password that gets encrypted.
+// Values changed since Load or taken from elsewhere are left alone
+func recordMigrated(path string, value interface{}) {
+	layerState.Lock()
+	defer layerState.Unlock()
+	origin, ok := layerState.layers[path]
+	if !ok || origin.layer != Layer_User || origin.source != Source_File || !reflect.DeepEqual(layerState.loaded[path], value) {
+		return
+	}
+	origin.source = Source_Migrated
+	layerState.layers[path] = origin
+}
+
+// recordConverted records all values of the config file as migrated after it has been converted to another format
+func recordConverted(file string) {
+	layerState.Lock()
+	defer layerState.Unlock()
+	for path, origin := range layerState.layers {
+		if origin.layer == Layer_User && origin.source == Source_File {
+			layerState.layers[path] = valueOrigin{layer: Layer_User, source: Source_Migrated, file: file}
+		}
+	}
+}
+
+// GetProvenance returns every leaf value of the last Load with its source, sorted by path
+func GetProvenance() []Provenance {
+	layerState.Lock()
+	defer layerState.Unlock()
+
+	provenance := make([]Provenance, 0, len(layerState.loaded))
+	for path, value := range layerState.loaded {
+		origin, ok := layerState.layers[path]
+		if !ok {
+			origin = valueOrigin{layer: Layer_Default, source: Source_Default}
+		}
+		provenance = append(provenance, Provenance{Path: path, Value: value, Source: origin.source, Layer: origin.layer, File: origin.file, Line: origin.line})
+	}
+	sort.Slice(provenance, func(i, j int) bool { return provenance[i].Path < provenance[j].Path })
+	return provenance
+}
+
+// WriteProvenance prints every value of the last Load with its source, eg. for support bundles. Values of password and secret fields in the schema are masked
+func WriteProvenance(w io.Writer, schema *cs.ValueTypeDescriptor) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, p := range GetProvenance() {
+		value := formatProvenanceValue(p.Value)
+		if isSecret(descriptorAtPath(schema, schemaPath(p.Path))) && p.Value != "" {
+			value = RedactedValue
+		}
+
+		source := p.Source
+		if p.Source == Source_File {
+			source = fmt.Sprintf("%s %s", p.Source, p.File)
+			if p.Line > 0 {
+				source += ":" + strconv.Itoa(p.Line)
+			}
+			if p.Layer != Layer_User {
+				source += " (" + p.Layer + ")"
+			}
+		}
+		if _, err := fmt.Fprintf(tw, "%s\t= %s\t%s\n", p.Path, value, source); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
+
+func formatProvenanceValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return strconv.Quote(s)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// schemaPath removes the indexes of array elements from a value path (eg. "Devices.0.Port" to "Devices.Port")
+func schemaPath(path string) string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(path, ".") {
+		if _, err := strconv.Atoi(name); err != nil {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ".")
+}
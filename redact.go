@@ -0,0 +1,136 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// RedactedValue replaces the values of password and secret fields in redacted exports
+const RedactedValue = "********"
+
+// isSecret returns true for fields that must not show up in exports, password fields and fields tagged ibSecret
+func isSecret(vtd *cs.ValueTypeDescriptor) bool {
+	return vtd != nil && (vtd.Type == cs.ValueType_Password || vtd.Secret)
+}
+
+// Redact returns a copy of a config with the values of all password fields and fields tagged ibSecret replaced by RedactedValue, eg. for support bundles.
+// The config can be a (pointer to a) config structure or the generic map used by ValidateConfig. Empty values are kept, to show that they are missing.
+// The result can be encoded as TOML, JSON or YAML, eg. with EncodeDocument
+func Redact(config interface{}, schema *cs.ValueTypeDescriptor) (map[string]interface{}, error) {
+	doc, err := configDocument(config)
+	if err != nil {
+		return nil, fmt.Errorf("can not redact: %w", err)
+	}
+
+	redacted, ok := redactValue(schema, doc).(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("schema root is no structure")
+	}
+	return redacted, nil
+}
+
+// configDocument returns the generic map of a (pointer to a) config structure, maps are returned as they are
+func configDocument(config interface{}) (map[string]interface{}, error) {
+	if doc, ok := config.(map[string]interface{}); ok {
+		return doc, nil
+	}
+	v := reflect.ValueOf(config)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%T is no config structure or map", config)
+	}
+	data, err := encodeConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return (tomlCodec{}).Unmarshal(data)
+}
+
+// RedactSchema returns a copy of a schema without the defaults of password fields and fields tagged ibSecret
+func RedactSchema(schema *cs.ValueTypeDescriptor) *cs.ValueTypeDescriptor {
+	if schema == nil {
+		return nil
+	}
+	redacted := *schema
+	if isSecret(schema) && schema.Default != nil {
+		redacted.Default = RedactedValue
+	}
+	redacted.ArraySubType = RedactSchema(schema.ArraySubType)
+	if schema.StructureSubtypes != nil {
+		redacted.StructureSubtypes = make(map[string]*cs.ValueTypeDescriptor, len(schema.StructureSubtypes))
+		for name, sub := range schema.StructureSubtypes {
+			redacted.StructureSubtypes[name] = RedactSchema(sub)
+		}
+	}
+	return &redacted
+}
+
+func redactValue(vtd *cs.ValueTypeDescriptor, value interface{}) interface{} {
+	if vtd == nil || value == nil {
+		return value
+	}
+	if isSecret(vtd) {
+		if value == "" {
+			return value
+		}
+		return RedactedValue
+	}
+
+	switch vtd.Type {
+	case cs.ValueType_Structure:
+		if table, ok := value.(map[string]interface{}); ok {
+			return redactTable(vtd, table)
+		}
+	case cs.ValueType_StructureArray:
+		switch elements := value.(type) {
+		case []map[string]interface{}:
+			redacted := make([]map[string]interface{}, len(elements))
+			for i, element := range elements {
+				redacted[i] = redactTable(vtd, element)
+			}
+			return redacted
+		case []interface{}:
+			redacted := make([]interface{}, len(elements))
+			for i, element := range elements {
+				if table, ok := element.(map[string]interface{}); ok {
+					redacted[i] = redactTable(vtd, table)
+				} else {
+					redacted[i] = element
+				}
+			}
+			return redacted
+		}
+	case cs.ValueType_Array:
+		if elements, ok := value.([]interface{}); ok {
+			redacted := make([]interface{}, len(elements))
+			for i, element := range elements {
+				redacted[i] = redactValue(vtd.ArraySubType, element)
+			}
+			return redacted
+		}
+	}
+	return value
+}
+
+// redactTable redacts the fields of a table, keys are matched with the fields of the schema by the rules of documentKey
+func redactTable(vtd *cs.ValueTypeDescriptor, table map[string]interface{}) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(table))
+	for key, value := range table {
+		sub, ok := vtd.StructureSubtypes[key]
+		if !ok {
+			for name, candidate := range vtd.StructureSubtypes {
+				if strings.EqualFold(name, key) {
+					sub = candidate
+					break
+				}
+			}
+		}
+		redacted[key] = redactValue(sub, value)
+	}
+	return redacted
+}
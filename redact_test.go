@@ -0,0 +1,74 @@
+package config_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+)
+
+func TestRedact(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP       string
+		Password string `ibValidate:"password"`
+		Username string
+	}
+	type Config struct {
+		Token   string `ibSecret:"true" ibDefault:"default-token"`
+		PollMs  int
+		Devices []DeviceConfig
+	}
+
+	config := Config{
+		Token:  "abc123",
+		PollMs: 100,
+		Devices: []DeviceConfig{
+			{IP: "10.0.0.1", Username: "admin", Password: "secret"},
+			{IP: "10.0.0.2"},
+		},
+	}
+	schema := conf.GetSchema(&config)
+	if !schema.StructureSubtypes["Token"].Secret {
+		t.Fatalf("expected ibSecret to mark the field as secret")
+	}
+
+	redacted, err := conf.Redact(&config, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := redacted["Devices"].([]map[string]interface{})
+	if redacted["Token"] != conf.RedactedValue || devices[0]["Password"] != conf.RedactedValue || devices[1]["Password"] != "" {
+		t.Errorf("expected secrets to be redacted, got %v", redacted)
+	}
+	if devices[0]["Username"] != "admin" || redacted["PollMs"] != int64(100) {
+		t.Errorf("expected other values to be kept, got %v", redacted)
+	}
+	if config.Devices[0].Password != "secret" {
+		t.Errorf("expected config to be unchanged")
+	}
+
+	generic := map[string]interface{}{
+		"Token":   "abc123",
+		"Devices": []interface{}{map[string]interface{}{"IP": "10.0.0.1", "password": "secret"}},
+	}
+	redacted, err = conf.Redact(generic, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(redacted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") || strings.Contains(string(data), "abc123") {
+		t.Errorf("expected secrets of generic config to be redacted, got %s", data)
+	}
+	if _, err := conf.EncodeDocument(conf.ConfigFormat_TOML, redacted); err != nil {
+		t.Errorf("expected redacted config to encode as toml: %v", err)
+	}
+
+	if conf.RedactSchema(schema).StructureSubtypes["Token"].Default != conf.RedactedValue || schema.StructureSubtypes["Token"].Default != "default-token" {
+		t.Errorf("expected default of secret field to be redacted in a copy of the schema")
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+// Prefixes of references in password fields, the secret is read from a file or an environment variable on Load
+const (
+	secretRefFile = "file:"
+	secretRefEnv  = "env:"
+)
+
+// secretRefEscape in front of a reference prefix marks a plain text password, eg. \env:abc is the password "env:abc"
+const secretRefEscape = `\`
+
+type secretReference struct {
+	reference string
+	resolved  string
+}
+
+// secretReferences are the references of the last Load by their secretKey, to write them back on Save
+var secretReferences = make(map[string]secretReference)
+var secretReferencesMu sync.Mutex
+
+// isSecretReference returns true for values like "file:/run/secrets/cam1" or "env:CAM1_PASS"
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretRefFile) || strings.HasPrefix(value, secretRefEnv)
+}
+
+// unescapeSecret returns the password of an escaped value like \env:abc, only one escape is removed so passwords starting with \env: can be escaped as well
+func unescapeSecret(value string) (string, bool) {
+	if strings.HasPrefix(value, secretRefEscape) && isSecretReference(strings.TrimLeft(value, secretRefEscape)) {
+		return strings.TrimPrefix(value, secretRefEscape), true
+	}
+	return value, false
+}
+
+// escapeSecret escapes passwords that would otherwise be read as a reference or unescaped on the next Load
+func escapeSecret(value string) string {
+	if isSecretReference(strings.TrimLeft(value, secretRefEscape)) {
+		return secretRefEscape + value
+	}
+	return value
+}
+
+// validateSecretReference checks the syntax of a reference, other values are always valid
+func validateSecretReference(value string) error {
+	switch {
+	case strings.HasPrefix(value, secretRefFile):
+		if strings.TrimPrefix(value, secretRefFile) == "" {
+			return fmt.Errorf("secret reference %q has no file", value)
+		}
+	case strings.HasPrefix(value, secretRefEnv):
+		name := strings.TrimPrefix(value, secretRefEnv)
+		if name == "" {
+			return fmt.Errorf("secret reference %q has no variable name", value)
+		}
+		for i, r := range name {
+			if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || i > 0 && r >= '0' && r <= '9') {
+				return fmt.Errorf("secret reference %q is no valid variable name", value)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveSecret reads the secret of a reference, trailing newlines of files are removed
+func resolveSecret(reference string) (string, error) {
+	if err := validateSecretReference(reference); err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(reference, secretRefEnv) {
+		value, ok := os.LookupEnv(strings.TrimPrefix(reference, secretRefEnv))
+		if !ok {
+			return "", fmt.Errorf("environment variable of %s is not set", reference)
+		}
+		return value, nil
+	}
+	data, err := os.ReadFile(strings.TrimPrefix(reference, secretRefFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveSecretReferences replaces the references in password fields of a toml document with their secrets
+func resolveSecretReferences(data []byte, schema *cs.ValueTypeDescriptor) ([]byte, error) {
+	doc := make(map[string]interface{})
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("on reading secret references: %w", err)
+	}
+	references := make(map[string]secretReference)
+	data, err := transformPasswords(data, schema, func(path, value string) (string, error) {
+		if unescaped, escaped := unescapeSecret(value); escaped {
+			references[secretKey(doc, schema, path)] = secretReference{reference: value, resolved: unescaped}
+			return unescaped, nil
+		}
+		if !isSecretReference(value) {
+			return value, nil
+		}
+		resolved, err := resolveSecret(value)
+		if err != nil {
+			return "", fmt.Errorf("on resolving %s: %w", path, err)
+		}
+		references[secretKey(doc, schema, path)] = secretReference{reference: value, resolved: resolved}
+		return resolved, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	secretReferencesMu.Lock()
+	secretReferences = references
+	secretReferencesMu.Unlock()
+	return data, nil
+}
+
+// restoreSecretReferences writes the references back into password fields whose secret has not been changed since Load,
+// other passwords that look like a reference are escaped
+func restoreSecretReferences(data []byte, schema *cs.ValueTypeDescriptor) ([]byte, error) {
+	secretReferencesMu.Lock()
+	defer secretReferencesMu.Unlock()
+
+	doc := make(map[string]interface{})
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("on restoring secret references: %w", err)
+	}
+	return transformPasswords(data, schema, func(path, value string) (string, error) {
+		if ref, ok := secretReferences[secretKey(doc, schema, path)]; ok && ref.resolved == value {
+			return ref.reference, nil
+		}
+		return escapeSecret(value), nil
+	})
+}
+
+// secretKey returns the key a reference is stored under: the path of its field, with the index of structure array elements
+// replaced by their device id (eg. "Devices.#3.Password"), so references stay with their device when elements move
+func secretKey(doc map[string]interface{}, schema *cs.ValueTypeDescriptor, path string) string {
+	segments := strings.Split(path, ".")
+	var value interface{} = doc
+	vtd := schema
+	for i, segment := range segments {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			value = v[documentKey(v, segment)]
+			if vtd != nil {
+				vtd = vtd.StructureSubtypes[segment]
+			}
+		case []map[string]interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(v) {
+				return path
+			}
+			value = v[index]
+			if vtd == nil || vtd.Type != cs.ValueType_StructureArray {
+				continue
+			}
+			if field := DispatchField(vtd, Dispatch_DeviceID); field != "" {
+				if id := v[index][documentKey(v[index], field)]; id != nil {
+					if number, ok := intType(id); ok {
+						segments[i] = "#" + strconv.Itoa(number)
+					}
+				}
+			}
+		default:
+			return path
+		}
+	}
+	return strings.Join(segments, ".")
+}
@@ -0,0 +1,393 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+// pathSeparator joins the keys of a path internally, keys in toml may contain dots themselves
+const pathSeparator = "\x1f"
+
+// tomlEntry is a key/value line found in a toml document, start and end are the byte offsets of the value
+type tomlEntry struct {
+	path       string
+	lineStart  int
+	start, end int
+}
+
+// tomlTable is a table found in a toml document, new keys are inserted at insertAt with the indentation of the existing keys
+type tomlTable struct {
+	headerStart int // Offset of the line of the table header, -1 for the root table
+	insertAt    int
+	indent      string
+}
+
+// patchTOML updates the values of an existing toml document to the ones of a freshly encoded document.
+// Comments, key order and formatting of the existing document are kept, keys missing in existing tables are added.
+// It returns false if the documents differ in shape (removed keys, new tables, changed array lengths) or a value
+// can not be patched in place
+func patchTOML(existing, encoded []byte) ([]byte, bool) {
+	oldValues, newValues := make(map[string]interface{}), make(map[string]interface{})
+	if err := toml.Unmarshal(existing, &oldValues); err != nil {
+		return nil, false
+	}
+	if err := toml.Unmarshal(encoded, &newValues); err != nil {
+		return nil, false
+	}
+
+	oldLeaves, newLeaves := make(map[string]interface{}), make(map[string]interface{})
+	flattenTOML("", oldValues, oldLeaves)
+	flattenTOML("", newValues, newLeaves)
+	for path := range oldLeaves {
+		if _, ok := newLeaves[path]; !ok {
+			return nil, false
+		}
+	}
+	changed := make(map[string]interface{})
+	for path, newValue := range newLeaves {
+		if oldValue, ok := oldLeaves[path]; ok && !reflect.DeepEqual(oldValue, newValue) {
+			if strings.HasSuffix(path, pathSeparator+"#") || path == "#" {
+				return nil, false // Array of tables changed its length
+			}
+			changed[path] = newValue
+		}
+	}
+	if len(changed) == 0 && len(oldLeaves) == len(newLeaves) {
+		return existing, true
+	}
+
+	entries, tables, err := scanTOML(string(existing))
+	if err != nil {
+		return nil, false
+	}
+	newEntries, _, err := scanTOML(string(encoded))
+	if err != nil {
+		return nil, false
+	}
+
+	type replacement struct {
+		start, end int
+		text       string
+		seq        int
+	}
+	replacements := make([]replacement, 0, len(changed))
+	for _, entry := range entries {
+		value, ok := changed[entry.path]
+		if !ok {
+			continue
+		}
+		text, err := encodeTOMLValue(value)
+		if err != nil {
+			return nil, false
+		}
+		replacements = append(replacements, replacement{entry.start, entry.end, text, len(replacements)})
+		delete(changed, entry.path)
+	}
+	if len(changed) > 0 { // Values inside of inline tables or similar, that can not be found by key
+		return nil, false
+	}
+
+	for _, entry := range newEntries { // Use the order of the encoded document for new keys
+		if _, ok := oldLeaves[entry.path]; ok {
+			continue
+		}
+		keys := strings.Split(entry.path, pathSeparator)
+		table, ok := tables[strings.Join(keys[:len(keys)-1], pathSeparator)]
+		if !ok {
+			return nil, false
+		}
+		text, err := encodeTOMLValue(newLeaves[entry.path])
+		if err != nil {
+			return nil, false
+		}
+		line := table.indent + encodeTOMLKey(keys[len(keys)-1]) + " = " + text + "\n"
+		if table.insertAt > 0 && existing[table.insertAt-1] != '\n' {
+			line = "\n" + line // Last line of the file without line break
+		}
+		replacements = append(replacements, replacement{table.insertAt, table.insertAt, line, len(replacements)})
+	}
+
+	// Apply from the end, so offsets stay valid. Insertions at the same offset are applied in reverse to keep their order
+	sort.Slice(replacements, func(i, j int) bool {
+		if replacements[i].start != replacements[j].start {
+			return replacements[i].start > replacements[j].start
+		}
+		return replacements[i].seq > replacements[j].seq
+	})
+	patched := string(existing)
+	for _, r := range replacements {
+		patched = patched[:r.start] + r.text + patched[r.end:]
+	}
+
+	// Make sure the patched document holds exactly the new values
+	check := make(map[string]interface{})
+	if err := toml.Unmarshal([]byte(patched), &check); err != nil || !reflect.DeepEqual(check, newValues) {
+		return nil, false
+	}
+	return []byte(patched), true
+}
+
+// flattenTOML collects the leaf values of a decoded toml document by path, tables in arrays are addressed by index
+func flattenTOML(prefix string, value interface{}, out map[string]interface{}) {
+	join := func(key string) string {
+		if prefix == "" {
+			return key
+		}
+		return prefix + pathSeparator + key
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, sub := range v {
+			flattenTOML(join(key), sub, out)
+		}
+	case []map[string]interface{}:
+		out[join("#")] = len(v) // Keep the length, so added or removed elements count as a change of shape
+		for i, sub := range v {
+			flattenTOML(join(strconv.Itoa(i)), sub, out)
+		}
+	default:
+		out[prefix] = value
+	}
+}
+
+// encodeTOMLValue returns the toml representation of a single inline value
+func encodeTOMLValue(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(map[string]interface{}{"v": value}); err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(buf.String())
+	if !strings.HasPrefix(line, "v = ") || strings.Contains(line, "\n") {
+		return "", fmt.Errorf("value can not be written inline")
+	}
+	return strings.TrimPrefix(line, "v = "), nil
+}
+
+func encodeTOMLKey(key string) string {
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return strconv.Quote(key)
+		}
+	}
+	if key == "" {
+		return `""`
+	}
+	return key
+}
+
+// scanTOML finds all key/value entries of a toml document with the full path of their key, and all tables with the offset after their last entry
+func scanTOML(doc string) ([]tomlEntry, map[string]*tomlTable, error) {
+	var entries []tomlEntry
+	table := []string{}
+	tables := map[string]*tomlTable{"": {headerStart: -1, insertAt: 0}}
+	arrayCounts := make(map[string]int)
+
+	// resolve replaces the names of arrays of tables with the current element
+	resolve := func(keys []string) []string {
+		resolved := make([]string, 0, len(keys)*2)
+		for _, key := range keys {
+			resolved = append(resolved, key)
+			if count, ok := arrayCounts[strings.Join(resolved, pathSeparator)]; ok {
+				resolved = append(resolved, strconv.Itoa(count-1))
+			}
+		}
+		return resolved
+	}
+
+	i := 0
+	for i < len(doc) {
+		switch c := doc[i]; {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '#':
+			i = skipLine(doc, i)
+		case c == '[':
+			arrayTable := strings.HasPrefix(doc[i:], "[[")
+			open, close := "[", "]"
+			if arrayTable {
+				open, close = "[[", "]]"
+			}
+			end := strings.Index(doc[i:], close)
+			if end < 0 {
+				return nil, nil, fmt.Errorf("unterminated table header")
+			}
+			keys, err := parseTOMLKey(doc[i+len(open) : i+end])
+			if err != nil {
+				return nil, nil, err
+			}
+			if arrayTable {
+				parent := resolve(keys[:len(keys)-1])
+				arrayPath := strings.Join(append(parent, keys[len(keys)-1]), pathSeparator)
+				arrayCounts[arrayPath]++
+				table = append(append(parent, keys[len(keys)-1]), strconv.Itoa(arrayCounts[arrayPath]-1))
+			} else {
+				table = resolve(keys)
+			}
+			headerStart := strings.LastIndexByte(doc[:i], '\n') + 1
+			i = skipLine(doc, i+end)
+			tables[strings.Join(table, pathSeparator)] = &tomlTable{headerStart: headerStart, insertAt: i}
+		default:
+			lineStart := strings.LastIndexByte(doc[:i], '\n') + 1
+			eq := strings.Index(doc[i:], "=")
+			if eq < 0 {
+				return nil, nil, fmt.Errorf("missing = after key")
+			}
+			keys, err := parseTOMLKey(doc[i : i+eq])
+			if err != nil {
+				return nil, nil, err
+			}
+			start := i + eq + 1
+			for start < len(doc) && (doc[start] == ' ' || doc[start] == '\t') {
+				start++
+			}
+			end, err := scanTOMLValue(doc, start)
+			if err != nil {
+				return nil, nil, err
+			}
+			entries = append(entries, tomlEntry{path: strings.Join(append(append([]string{}, table...), keys...), pathSeparator), lineStart: lineStart, start: start, end: end})
+			i = skipLine(doc, end)
+
+			if current, ok := tables[strings.Join(table, pathSeparator)]; ok && len(keys) == 1 {
+				current.insertAt = i
+				current.indent = doc[lineStart : lineStart+len(doc[lineStart:])-len(strings.TrimLeft(doc[lineStart:], " \t"))]
+			}
+		}
+	}
+	return entries, tables, nil
+}
+
+func skipLine(doc string, i int) int {
+	if end := strings.IndexByte(doc[i:], '\n'); end >= 0 {
+		return i + end + 1
+	}
+	return len(doc)
+}
+
+// parseTOMLKey splits a bare, quoted or dotted key into its parts
+func parseTOMLKey(raw string) ([]string, error) {
+	var keys []string
+	raw = strings.TrimSpace(raw)
+	for raw != "" {
+		var key string
+		switch raw[0] {
+		case '"':
+			end, err := scanTOMLValue(raw, 0)
+			if err != nil {
+				return nil, err
+			}
+			key, err = strconv.Unquote(raw[:end])
+			if err != nil {
+				return nil, err
+			}
+			raw = raw[end:]
+		case '\'':
+			end := strings.IndexByte(raw[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated key")
+			}
+			key, raw = raw[1:end+1], raw[end+2:]
+		default:
+			end := strings.IndexAny(raw, ". \t")
+			if end < 0 {
+				end = len(raw)
+			}
+			key, raw = raw[:end], raw[end:]
+		}
+		keys = append(keys, key)
+
+		raw = strings.TrimSpace(raw)
+		if raw != "" {
+			if raw[0] != '.' {
+				return nil, fmt.Errorf("invalid key")
+			}
+			raw = strings.TrimSpace(raw[1:])
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("empty key")
+	}
+	return keys, nil
+}
+
+// scanTOMLValue returns the end offset of the value starting at i, values may span multiple lines
+func scanTOMLValue(doc string, i int) (int, error) {
+	if i >= len(doc) {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	switch {
+	case strings.HasPrefix(doc[i:], `"""`), strings.HasPrefix(doc[i:], `'''`):
+		delimiter := doc[i : i+3]
+		for j := i + 3; j < len(doc); j++ {
+			if delimiter == `"""` && doc[j] == '\\' {
+				j++
+				continue
+			}
+			if strings.HasPrefix(doc[j:], delimiter) {
+				for j+3 < len(doc) && doc[j+3] == delimiter[0] { // Up to two quotes are allowed right before the delimiter
+					j++
+				}
+				return j + 3, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated multi-line string")
+	case doc[i] == '"':
+		for j := i + 1; j < len(doc) && doc[j] != '\n'; j++ {
+			if doc[j] == '\\' {
+				j++
+				continue
+			}
+			if doc[j] == '"' {
+				return j + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated string")
+	case doc[i] == '\'':
+		for j := i + 1; j < len(doc) && doc[j] != '\n'; j++ {
+			if doc[j] == '\'' {
+				return j + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated string")
+	case doc[i] == '[' || doc[i] == '{':
+		open := doc[i]
+		close := byte(']')
+		if open == '{' {
+			close = '}'
+		}
+		depth := 0
+		for j := i; j < len(doc); j++ {
+			switch doc[j] {
+			case '"', '\'':
+				end, err := scanTOMLValue(doc, j)
+				if err != nil {
+					return 0, err
+				}
+				j = end - 1
+			case '#':
+				j = skipLine(doc, j) - 1
+			case open:
+				depth++
+			case close:
+				depth--
+				if depth == 0 {
+					return j + 1, nil
+				}
+			}
+		}
+		return 0, fmt.Errorf("unterminated %c", open)
+	}
+
+	j := i
+	for j < len(doc) && !strings.ContainsRune(" \t\r\n#,]}", rune(doc[j])) {
+		j++
+	}
+	return j, nil
+}
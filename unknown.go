@@ -0,0 +1,128 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// orphanedSection is the table unknown keys are preserved in when SetPreserveOrphaned is enabled
+const orphanedSection = "orphaned"
+
+var strictLoad bool = false
+var preserveOrphaned bool = false
+
+var orphaned = make(map[string]interface{})
+var orphanedMu sync.Mutex
+
+// SetStrictLoad makes Load fail if the config file contains keys that do not exist in the config structure
+func SetStrictLoad(strict bool) {
+	strictLoad = strict
+}
+
+// SetPreserveOrphaned keeps unknown keys found on Load in an "orphaned" section of the config file instead of dropping them on the next Save
+func SetPreserveOrphaned(preserve bool) {
+	preserveOrphaned = preserve
+}
+
+// GetOrphaned returns the unknown keys found on the last Load with their values, keys include the index of array elements (eg. "Devices.0.IpAdress")
+func GetOrphaned() map[string]interface{} {
+	orphanedMu.Lock()
+	defer orphanedMu.Unlock()
+	copied := make(map[string]interface{}, len(orphaned))
+	for key, value := range orphaned {
+		copied[key] = value
+	}
+	return copied
+}
+
+// collectUnknownKeys returns the paths of all keys the decoder could not match to the structure and stores their values as orphaned.
+// Only keys of the user layer are stored, Save would otherwise copy unknown keys of vendor and drop-in files into the user file
+func collectUnknownKeys(md toml.MetaData, raw map[string]interface{}) []string {
+	undecoded := make(map[string]bool)
+	for _, key := range md.Undecoded() {
+		undecoded[key.String()] = true
+	}
+
+	layerState.Lock()
+	user := layerState.user
+	layerState.Unlock()
+
+	orphanedMu.Lock()
+	defer orphanedMu.Unlock()
+	orphaned = make(map[string]interface{})
+
+	if previous, ok := user[orphanedSection].(map[string]interface{}); ok { // Keys preserved on an earlier save
+		for key, value := range previous {
+			orphaned[key] = value
+		}
+	}
+
+	keys := make([]string, 0)
+	for _, key := range md.Undecoded() {
+		parts := []string(key)
+		if parts[0] == orphanedSection || (len(parts) > 1 && undecoded[toml.Key(parts[:len(parts)-1]).String()]) {
+			continue // Only report the topmost unknown key
+		}
+		for path := range rawValuesAt(raw, parts, "") {
+			keys = append(keys, path)
+		}
+		for path, value := range rawValuesAt(user, parts, "") {
+			orphaned[path] = value
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// rawValuesAt returns the values of a key by path, keys inside of arrays of tables exist once per element
+func rawValuesAt(raw map[string]interface{}, key []string, prefix string) map[string]interface{} {
+	values := make(map[string]interface{})
+	value, ok := raw[key[0]]
+	if !ok {
+		return values
+	}
+	path := joinPath(prefix, key[0])
+	if len(key) == 1 {
+		values[path] = value
+		return values
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return rawValuesAt(v, key[1:], path)
+	case []map[string]interface{}:
+		for i, element := range v {
+			for p, elementValue := range rawValuesAt(element, key[1:], joinPath(path, strconv.Itoa(i))) {
+				values[p] = elementValue
+			}
+		}
+	}
+	return values
+}
+
+// encodeOrphaned returns the orphaned section to append to a saved config
+func encodeOrphaned() ([]byte, error) {
+	orphanedMu.Lock()
+	defer orphanedMu.Unlock()
+	if !preserveOrphaned || len(orphaned) == 0 {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("\n")
+	err := toml.NewEncoder(&buf).Encode(map[string]interface{}{orphanedSection: orphaned})
+	if err != nil {
+		return nil, fmt.Errorf("on encoding orphaned keys: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func unknownKeysError(keys []string) error {
+	return fmt.Errorf("unknown keys in config: %s", strings.Join(keys, ", "))
+}
@@ -2,6 +2,8 @@ package config
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
 
 	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
 	"github.com/oxequa/grace"
@@ -22,6 +24,12 @@ func ValidateConfig(schema *cs.ValueTypeDescriptor, values interface{}, strictMo
 		return values, nil
 	}
 
+	if count, ok := itemCount(values); ok && hasItems(schema) {
+		if err := validateItemCount(schema, count); err != nil {
+			return nil, err
+		}
+	}
+
 	switch schema.Type {
 	case cs.ValueType_Unknown:
 		log.Debug("found unknown type in config!")
@@ -34,7 +42,13 @@ func ValidateConfig(schema *cs.ValueTypeDescriptor, values interface{}, strictMo
 		values = intVal
 
 	case cs.ValueType_Float:
-		if _, ok := values.(float64); !ok {
+		switch v := values.(type) {
+		case float64:
+		case int64: // Whole numbers are integers in toml, eg. of fields that changed from int to float
+			values = float64(v)
+		case int:
+			values = float64(v)
+		default:
 			return nil, fmt.Errorf("float is no float, but %T", values)
 		}
 
@@ -168,6 +182,9 @@ func ValidateConfig(schema *cs.ValueTypeDescriptor, values interface{}, strictMo
 		if _, ok := values.(string); !ok {
 			return nil, fmt.Errorf("password is no string, but %T", values)
 		}
+		if err := validateSecretReference(values.(string)); err != nil {
+			return nil, err
+		}
 
 	case cs.ValueType_Select:
 		if _, ok := values.(string); !ok {
@@ -178,6 +195,43 @@ func ValidateConfig(schema *cs.ValueTypeDescriptor, values interface{}, strictMo
 			return nil, fmt.Errorf("invalid select option %q", values.(string))
 		}
 
+	case cs.ValueType_IntegerSelect:
+		intVal, ok := intType(values)
+		if !ok {
+			return nil, fmt.Errorf("integer select is no integertype, but %T", values)
+		}
+		values = intVal
+
+		if !containsString(schema.Options, strconv.Itoa(intVal)) { // Unlike the empty select, 0 is a value and has to be an option
+			return nil, fmt.Errorf("invalid select option %d", intVal)
+		}
+
+	case cs.ValueType_MultiSelect:
+		if values == nil {
+			return values, nil
+		}
+
+		switch selected := values.(type) {
+		case []string:
+			for id, option := range selected {
+				if !containsString(schema.Options, option) {
+					return nil, fmt.Errorf("invalid select option %q at index %d", option, id)
+				}
+			}
+		case []interface{}:
+			for id, v := range selected {
+				option, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("multi select value at index %d is no string, but %T", id, v)
+				}
+				if !containsString(schema.Options, option) {
+					return nil, fmt.Errorf("invalid select option %q at index %d", option, id)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("multi select is no array, but %T", values)
+		}
+
 	case cs.ValueType_UniqueInc:
 		// TODO: it might make sense to actually check uniqueness, here at some point
 
@@ -189,6 +243,14 @@ func ValidateConfig(schema *cs.ValueTypeDescriptor, values interface{}, strictMo
 		values = intVal
 	}
 
+	if len(schema.Models) > 0 {
+		if id, ok := values.(int); ok {
+			if err := validateModel(schema, id); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	return values, nil
 }
 
@@ -207,3 +269,136 @@ func intType(values interface{}) (int, bool) {
 	}
 	return 0, false
 }
+
+// ValidationError is a single violation found by ValidateConfigAll
+type ValidationError struct {
+	Path string // Dotted path of the value, array elements are addressed by index (eg. "Devices.0.Port")
+	Err  error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateConfigAll validates a config structure against a schema like ValidateConfig, but does not stop on the first violation.
+// All violations are returned with the path of the value they were found on
+func ValidateConfigAll(schema *cs.ValueTypeDescriptor, values interface{}, strictMode bool, nameForWarnings string) (cleanedValue interface{}, errs []*ValidationError) {
+	v := &allValidator{strictMode: strictMode, nameForWarnings: nameForWarnings}
+	cleanedValue = v.validate(schema, values, "")
+	return cleanedValue, v.errs
+}
+
+type allValidator struct {
+	strictMode      bool
+	nameForWarnings string
+	errs            []*ValidationError
+}
+
+func (v *allValidator) report(path string, err error) {
+	v.errs = append(v.errs, &ValidationError{Path: path, Err: err})
+}
+
+func (v *allValidator) validate(schema *cs.ValueTypeDescriptor, values interface{}, path string) interface{} {
+	if schema == nil {
+		if v.strictMode {
+			v.report(path, fmt.Errorf("schema is not defined"))
+		}
+		return values
+	}
+
+	if schema.Type == cs.ValueType_Array || schema.Type == cs.ValueType_StructureArray { // Other types are checked by ValidateConfig
+		if count, ok := itemCount(values); ok {
+			if err := validateItemCount(schema, count); err != nil {
+				v.report(path, err)
+			}
+		}
+	}
+
+	switch schema.Type {
+	case cs.ValueType_Structure:
+		valueMap, ok := values.(map[string]interface{})
+		if !ok {
+			v.report(path, fmt.Errorf("structure is no object"))
+			return values
+		}
+		v.validateStructure(schema, valueMap, path)
+
+	case cs.ValueType_Array:
+		if values == nil {
+			return values
+		}
+		valueMap, ok := values.([]interface{})
+		if !ok {
+			v.report(path, fmt.Errorf("array is no array"))
+			return values
+		}
+		for id, value := range valueMap {
+			valueMap[id] = v.validate(schema.ArraySubType, value, joinPath(path, strconv.Itoa(id)))
+		}
+
+	case cs.ValueType_StructureArray:
+		switch valueMap := values.(type) {
+		case nil:
+		case []map[string]interface{}:
+			for id, structureValue := range valueMap {
+				v.validateStructure(schema, structureValue, joinPath(path, strconv.Itoa(id)))
+			}
+		case []interface{}:
+			for id, structureValue := range valueMap {
+				sv, ok := structureValue.(map[string]interface{})
+				if !ok {
+					v.report(joinPath(path, strconv.Itoa(id)), fmt.Errorf("structure is no object"))
+					continue
+				}
+				v.validateStructure(schema, sv, joinPath(path, strconv.Itoa(id)))
+			}
+		default:
+			v.report(path, fmt.Errorf("structured array is no array, but %T", values))
+		}
+
+	default:
+		cleaned, err := ValidateConfig(schema, values, v.strictMode, v.nameForWarnings)
+		if err != nil {
+			v.report(path, err)
+			return values
+		}
+		return cleaned
+	}
+
+	return values
+}
+
+func (v *allValidator) validateStructure(schema *cs.ValueTypeDescriptor, valueMap map[string]interface{}, path string) {
+	names := make([]string, 0, len(valueMap))
+	for name := range valueMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		schemaValue, ok := schema.StructureSubtypes[name]
+		if !ok {
+			if v.strictMode {
+				v.report(joinPath(path, name), fmt.Errorf("value does not exist in schema"))
+			} else {
+				log.WithField("package", v.nameForWarnings).Debugf("config validator: value %s does not exist in schema", joinPath(path, name))
+			}
+			continue
+		}
+		valueMap[name] = v.validate(schemaValue, valueMap[name], joinPath(path, name))
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,227 @@
+package config_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	conf "github.com/SKAARHOJ/ibeam-lib-config"
+	cs "github.com/SKAARHOJ/ibeam-lib-config/configstructure"
+)
+
+func TestSelectOptions(t *testing.T) {
+	type Config struct {
+		BaudRate  int      `ibOptions:"9600,19200,115200"`
+		FrameRate uint32   `ibOptions:"25,50" ibDefault:"50"`
+		Inputs    []string `ibOptions:"SDI1,SDI2,HDMI"`
+	}
+
+	schema := conf.GetSchema(&Config{})
+	if schema.StructureSubtypes["BaudRate"].Type != cs.ValueType_IntegerSelect {
+		t.Errorf("expected integer select for BaudRate, got %d", schema.StructureSubtypes["BaudRate"].Type)
+	}
+	if schema.StructureSubtypes["FrameRate"].Default != 50 {
+		t.Errorf("expected default 50 for FrameRate, got %v", schema.StructureSubtypes["FrameRate"].Default)
+	}
+	if schema.StructureSubtypes["Inputs"].Type != cs.ValueType_MultiSelect {
+		t.Errorf("expected multi select for Inputs, got %d", schema.StructureSubtypes["Inputs"].Type)
+	}
+
+	valid := map[string]interface{}{
+		"BaudRate":  float64(19200),
+		"FrameRate": float64(25),
+		"Inputs":    []interface{}{"SDI1", "HDMI"},
+	}
+	if _, err := conf.ValidateConfig(schema, valid, true, "test"); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+	withZero := conf.GetSchema(&struct {
+		Gain int `ibOptions:"0,6,12"`
+	}{})
+	if _, err := conf.ValidateConfig(withZero, map[string]interface{}{"Gain": float64(0)}, true, "test"); err != nil {
+		t.Errorf("expected 0 to be valid if it is an option, got %v", err)
+	}
+
+	invalid := []map[string]interface{}{
+		{"BaudRate": float64(1234)},
+		{"BaudRate": float64(0)}, // 0 is no option
+		{"FrameRate": "25"},
+		{"Inputs": []interface{}{"SDI1", "SDI3"}},
+		{"Inputs": "SDI1"},
+	}
+	for _, values := range invalid {
+		if _, err := conf.ValidateConfig(schema, values, true, "test"); err == nil {
+			t.Errorf("expected error for %v", values)
+		}
+	}
+}
+
+func TestOptionsProvider(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		Input   string
+		Sources []string
+	}
+	type Config struct {
+		Devices []DeviceConfig
+	}
+
+	inputs := []string{"Camera 1", "Camera 2"}
+	conf.RegisterOptionsProvider("Devices.Input", func() []string { return inputs })
+	conf.RegisterOptionsProvider("Devices.Sources", func() []string { return inputs })
+
+	schema := conf.GetSchema(&Config{})
+	input := schema.StructureSubtypes["Devices"].StructureSubtypes["Input"]
+	if input.Type != cs.ValueType_Select || len(input.Options) != 2 {
+		t.Errorf("expected select with 2 options, got type %d with %v", input.Type, input.Options)
+	}
+	sources := schema.StructureSubtypes["Devices"].StructureSubtypes["Sources"]
+	if sources.Type != cs.ValueType_MultiSelect || len(sources.Options) != 2 {
+		t.Errorf("expected multi select with 2 options, got type %d with %v", sources.Type, sources.Options)
+	}
+
+	inputs = append(inputs, "Camera 3")
+	schema = conf.GetSchema(&Config{})
+	if options := schema.StructureSubtypes["Devices"].StructureSubtypes["Input"].Options; len(options) != 2 {
+		t.Errorf("expected cached options until the next refresh, got %v", options)
+	}
+	conf.RefreshOptions()
+	schema = conf.GetSchema(&Config{})
+	if options := schema.StructureSubtypes["Devices"].StructureSubtypes["Input"].Options; len(options) != 3 {
+		t.Errorf("expected updated options, got %v", options)
+	}
+
+	// Load reads the options once, although it generates the schema several times
+	calls := 0
+	conf.RegisterOptionsProvider("Devices.Input", func() []string {
+		calls++
+		return inputs
+	})
+	conf.SetDevMode(true)
+	conf.SetCoreName(filepath.Join(t.TempDir(), "core-options"))
+	if err := conf.Load(&Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Errorf("expected options provider to be called once per load, got %d calls", calls)
+	}
+
+	// Providers can use the package themselves and options of integer selects that are no integers are left out
+	type ChannelConfig struct {
+		Channel int
+	}
+	conf.RegisterOptionsProvider("Channel", func() []string {
+		conf.RegisterOptionsProvider("Devices.Input", func() []string { return inputs })
+		return []string{"1", "two", "3"}
+	})
+	channel := conf.GetSchema(&ChannelConfig{}).StructureSubtypes["Channel"]
+	if channel.Type != cs.ValueType_IntegerSelect || !reflect.DeepEqual(channel.Options, []string{"1", "3"}) {
+		t.Errorf("expected integer select with options 1 and 3, got type %d with %v", channel.Type, channel.Options)
+	}
+}
+
+func TestValidateConfigAll(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		Port uint16 `ibValidate:"port"`
+		Mode string `ibOptions:"Auto,Manual"`
+	}
+	type Config struct {
+		PollMs  int
+		Devices []DeviceConfig
+	}
+
+	values := map[string]interface{}{
+		"PollMs": "fast",
+		"Extra":  true,
+		"Devices": []interface{}{
+			map[string]interface{}{"Port": float64(70000), "Mode": "Bogus"},
+			map[string]interface{}{"Port": float64(80), "Mode": "Auto"},
+		},
+	}
+
+	_, errs := conf.ValidateConfigAll(conf.GetSchema(&Config{}), values, true, "test")
+	paths := make([]string, len(errs))
+	for i, err := range errs {
+		paths[i] = err.Path
+	}
+	expected := []string{"Devices.0.Mode", "Devices.0.Port", "Extra", "PollMs"}
+	if len(paths) != len(expected) {
+		t.Fatalf("expected violations at %v, got %v", expected, errs)
+	}
+	for i := range expected {
+		if paths[i] != expected[i] {
+			t.Errorf("expected violation at %s, got %s", expected[i], paths[i])
+		}
+	}
+
+	if cleaned := values["Devices"].([]interface{})[1].(map[string]interface{})["Port"]; cleaned != 80 {
+		t.Errorf("expected valid values to be cleaned, got %T", cleaned)
+	}
+}
+
+func TestItemLimits(t *testing.T) {
+	type DeviceConfig struct {
+		conf.BaseDeviceConfig
+		IP string `ibValidate:"ip"`
+	}
+	type Config struct {
+		Devices []DeviceConfig `ibMaxItems:"2"`
+		Inputs  []int          `ibMinItems:"1" ibMaxItems:"4"`
+	}
+
+	schema := conf.GetSchema(&Config{})
+	if devices, inputs := schema.StructureSubtypes["Devices"], schema.StructureSubtypes["Inputs"]; devices.MaxItems != 2 || inputs.MinItems != 1 || inputs.MaxItems != 4 {
+		t.Errorf("expected item limits in schema, got %d, %d and %d", devices.MaxItems, inputs.MinItems, inputs.MaxItems)
+	}
+
+	device := func(id int) map[string]interface{} { return map[string]interface{}{"DeviceID": float64(id)} }
+	valid := map[string]interface{}{"Devices": []interface{}{device(1), device(2)}, "Inputs": []interface{}{float64(1)}}
+	if _, err := conf.ValidateConfig(schema, valid, true, "test"); err != nil {
+		t.Errorf("expected valid config, got %v", err)
+	}
+	invalid := map[string]interface{}{"Devices": []interface{}{device(1), device(2), device(3)}}
+	if _, err := conf.ValidateConfig(schema, invalid, true, "test"); err == nil {
+		t.Errorf("expected error for too many devices and missing inputs")
+	}
+	_, errs := conf.ValidateConfigAll(schema, invalid, true, "test")
+	if len(errs) != 1 || errs[0].Path != "Devices" {
+		t.Errorf("expected too many devices, got %v", errs)
+	}
+	invalid["Inputs"] = []interface{}{}
+	if _, errs := conf.ValidateConfigAll(schema, invalid, true, "test"); len(errs) != 2 || errs[1].Path != "Inputs" {
+		t.Errorf("expected too many devices and too few inputs, got %v", errs)
+	}
+
+	if err := conf.SetItemLimits("Devices", 0, 8); err != nil {
+		t.Fatal(err)
+	}
+	defer conf.ClearItemLimits()
+	if err := conf.SetItemLimits("Devices", 3, 2); err == nil {
+		t.Errorf("expected error for minimum above maximum")
+	}
+	licensed := conf.GetSchema(&Config{})
+	if licensed.StructureSubtypes["Devices"].MaxItems != 8 {
+		t.Errorf("expected override of item limits, got %d", licensed.StructureSubtypes["Devices"].MaxItems)
+	}
+	if _, err := conf.ValidateConfig(licensed, map[string]interface{}{"Devices": []interface{}{device(1), device(2), device(3)}, "Inputs": []interface{}{float64(1)}}, true, "test"); err != nil {
+		t.Errorf("expected valid config with licensed limit, got %v", err)
+	}
+
+	changes := conf.CompareSchemas(licensed, schema)
+	if len(changes) != 1 || changes[0].Kind != conf.SchemaChange_ItemLimitsChanged || !changes[0].Breaking {
+		t.Errorf("expected breaking change of item limits, got %v", changes)
+	}
+
+	data, err := conf.ExportJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported, unsupported, err := conf.ImportJSONSchema(data)
+	if err != nil || len(unsupported) != 0 {
+		t.Fatalf("on importing schema: %v %v", err, unsupported)
+	}
+	if imported.StructureSubtypes["Devices"].MaxItems != 2 || imported.StructureSubtypes["Inputs"].MinItems != 1 {
+		t.Errorf("expected item limits to survive json schema round trip")
+	}
+}